@@ -1,6 +1,7 @@
 package node
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -13,12 +14,6 @@ import (
 	ps "github.com/prometheus/client_golang/prometheus"
 )
 
-var enableSlowLimiterTest = false
-
-func EnableSlowLimiterTest(t bool) {
-	enableSlowLimiterTest = t
-}
-
 // ErrSlowLimiterRefused indicated the write request is slow while applying so it is refused to avoid
 // slow down other write.
 var ErrSlowLimiterRefused = errors.New("refused by slow limiter")
@@ -30,51 +25,99 @@ const (
 	smallSlowThreshold = 20
 )
 
-var SlowRefuseCostMs = int64(600)
-var SlowHalfOpenSec = int64(15)
+var SlowRefuseCostMs atomic.Int64
+var SlowHalfOpenSec atomic.Int64
+
+func init() {
+	SlowRefuseCostMs.Store(600)
+	SlowHalfOpenSec.Store(15)
+}
 
 func RegisterSlowConfChanged() {
 	common.RegisterConfChangedHandler(common.ConfSlowLimiterRefuseCostMs, func(v interface{}) {
 		iv, ok := v.(int)
 		if ok {
-			atomic.StoreInt64(&SlowRefuseCostMs, int64(iv))
+			SlowRefuseCostMs.Store(int64(iv))
 		}
 	})
 	common.RegisterConfChangedHandler(common.ConfSlowLimiterHalfOpenSec, func(v interface{}) {
 		iv, ok := v.(int)
 		if ok {
-			atomic.StoreInt64(&SlowHalfOpenSec, int64(iv))
+			SlowHalfOpenSec.Store(int64(iv))
 		}
 	})
 }
 
-// SlowLimiter is used to limit some slow write command to avoid raft blocking
+// slowFeatureCounts holds the bucketed slow counters for a single "cmd|prefix"
+// feature. It is stored by pointer inside a sync.Map so RecordSlowCmd and
+// isHistorySlow update and read these counts without taking a lock.
+type slowFeatureCounts struct {
+	slow10  atomic.Int64
+	slow50  atomic.Int64
+	slow100 atomic.Int64
+}
+
+// SlowLimiter is used to limit some slow write command to avoid raft blocking.
+// Per-table admission is delegated to a tableBreaker (see slow_breaker.go);
+// slowCounter/slowFeatures are kept as the legacy node-wide fallback that only
+// trips once a majority of tables are already Open, to preserve the old
+// "whole node overloaded" behavior. The fallback's own state is lock-free:
+// slowFeatures is a sync.Map of "cmd prefix" -> *slowFeatureCounts instead
+// of three mutex-guarded maps. CanPass and RecordSlowCmd are not lock-free
+// as a whole: both take breakerMutex's read lock to find the table breaker
+// and then that breaker's own mutex.
 type SlowLimiter struct {
-	slowCounter int64
-
-	limiterOn  int32
-	mutex      sync.RWMutex
-	slow100s   map[string]int64
-	slow50s    map[string]int64
-	slow10s    map[string]int64
-	lastSlowTs int64
-	stopC      chan struct{}
-	wg         sync.WaitGroup
+	slowCounter atomic.Int64
+
+	limiterOn    atomic.Bool
+	slowFeatures sync.Map // string -> *slowFeatureCounts
+	lastSlowTs   atomic.Int64
+	stopC        chan struct{}
+	wg           sync.WaitGroup
+
+	breakerMutex sync.RWMutex
+	breakers     map[string]*tableBreaker
+
+	queue *admissionQueue
+
+	persistPath      string
+	persistStaleness time.Duration
+	persistStopC     chan struct{}
+	persistWg        sync.WaitGroup
+
+	// testMode speeds up the run() decay loop and slowCounter backoff for
+	// unit tests that can't afford to wait out the real multi-second
+	// timings. It is off by default and only ever flipped by tests, via
+	// EnableTestMode, on the specific SlowLimiter under test - never a
+	// package-level global other instances (or production) could pick up.
+	testMode atomic.Bool
+}
+
+// EnableTestMode turns on (or off) sl's faster, test-only timings. It must
+// be called before Start().
+func (sl *SlowLimiter) EnableTestMode(on bool) {
+	sl.testMode.Store(on)
 }
 
 func NewSlowLimiter() *SlowLimiter {
-	return &SlowLimiter{
-		limiterOn: int32(common.GetIntDynamicConf(common.ConfSlowLimiterSwitch)),
-		slow100s:  make(map[string]int64),
-		slow50s:   make(map[string]int64),
-		slow10s:   make(map[string]int64),
+	sl := &SlowLimiter{
+		breakers: make(map[string]*tableBreaker),
 	}
+	sl.limiterOn.Store(common.GetIntDynamicConf(common.ConfSlowLimiterSwitch) > 0)
+	sl.queue = newAdmissionQueue(sl)
+	return sl
 }
 
 func (sl *SlowLimiter) Start() {
 	sl.stopC = make(chan struct{})
 	sl.wg.Add(1)
 	go sl.run(sl.stopC)
+	sl.queue.start()
+	if sl.persistPath != "" {
+		sl.persistStopC = make(chan struct{})
+		sl.persistWg.Add(1)
+		go sl.runPersist(sl.persistStopC)
+	}
 }
 
 func (sl *SlowLimiter) Stop() {
@@ -83,12 +126,23 @@ func (sl *SlowLimiter) Stop() {
 		sl.stopC = nil
 	}
 	sl.wg.Wait()
+	sl.queue.stop()
+	if sl.persistStopC != nil {
+		close(sl.persistStopC)
+		sl.persistStopC = nil
+		sl.persistWg.Wait()
+	}
+	if sl.persistPath != "" {
+		if err := sl.saveSnapshot(); err != nil {
+			nodeLog.Warningf("failed to persist slow limiter snapshot to %v on stop: %v", sl.persistPath, err)
+		}
+	}
 }
 
 func (sl *SlowLimiter) run(stopC chan struct{}) {
 	defer sl.wg.Done()
 	checkInterval := time.Second * 2
-	if enableSlowLimiterTest {
+	if sl.testMode.Load() {
 		checkInterval = checkInterval / 4
 	}
 	ticker := time.NewTicker(checkInterval)
@@ -98,9 +152,9 @@ func (sl *SlowLimiter) run(stopC chan struct{}) {
 		case <-ticker.C:
 			// decr slow counter more quickly to reduce the time
 			// in mid or heavy slow state to avoid refuse too much write with lower cost
-			old := atomic.LoadInt64(&sl.slowCounter)
+			old := sl.slowCounter.Load()
 			nodeLog.Debugf("current slow %v , last slow ts: %v",
-				old, atomic.LoadInt64(&sl.lastSlowTs))
+				old, sl.lastSlowTs.Load())
 			decr := -1
 			if old >= heavySlowThreshold {
 				decr = -10
@@ -108,18 +162,18 @@ func (sl *SlowLimiter) run(stopC chan struct{}) {
 				decr = -2
 			}
 			// speed up for test
-			if enableSlowLimiterTest && old > 10 {
+			if sl.testMode.Load() && old > 10 {
 				decr *= 3
 			}
-			n := atomic.AddInt64(&sl.slowCounter, int64(decr))
+			n := sl.slowCounter.Add(int64(decr))
 			if old >= smallSlowThreshold && n < smallSlowThreshold {
 				// we only clear slow stats while we changed from real slow to no slow
 				nodeLog.Infof("the apply limiter is changed from slow %v to no slow: %v , last slow ts: %v",
-					old, n, atomic.LoadInt64(&sl.lastSlowTs))
+					old, n, sl.lastSlowTs.Load())
 				sl.clearSlows()
 			}
 			if n < 0 {
-				atomic.AddInt64(&sl.slowCounter, int64(-1*decr))
+				sl.slowCounter.Add(int64(-1 * decr))
 			}
 		case <-stopC:
 			return
@@ -145,41 +199,40 @@ func (sl *SlowLimiter) testSlowWrite5ms(cmd redcon.Command, ts int64) (interface
 }
 
 func (sl *SlowLimiter) TurnOn() {
-	atomic.StoreInt32(&sl.limiterOn, 1)
+	sl.limiterOn.Store(true)
 }
 
 func (sl *SlowLimiter) TurnOff() {
-	atomic.StoreInt32(&sl.limiterOn, 0)
+	sl.limiterOn.Store(false)
 }
 
 func (sl *SlowLimiter) isOn() bool {
-	return atomic.LoadInt32(&sl.limiterOn) > 0
+	return sl.limiterOn.Load()
 }
 
 func (sl *SlowLimiter) MarkHeavySlow() {
-	atomic.StoreInt64(&sl.slowCounter, maxSlowThreshold)
-	atomic.StoreInt64(&sl.lastSlowTs, time.Now().UnixNano())
+	sl.slowCounter.Store(maxSlowThreshold)
+	sl.lastSlowTs.Store(time.Now().UnixNano())
 }
 
 func (sl *SlowLimiter) clearSlows() {
 	if !sl.isOn() {
 		return
 	}
-	sl.mutex.Lock()
-	defer sl.mutex.Unlock()
-	if len(sl.slow100s) > 0 {
-		sl.slow100s = make(map[string]int64)
-	}
-	if len(sl.slow50s) > 0 {
-		sl.slow50s = make(map[string]int64)
-	}
-	if len(sl.slow10s) > 0 {
-		sl.slow10s = make(map[string]int64)
-	}
+	sl.slowFeatures.Range(func(k, v interface{}) bool {
+		fc := v.(*slowFeatureCounts)
+		fc.slow10.Store(0)
+		fc.slow50.Store(0)
+		fc.slow100.Store(0)
+		return true
+	})
 }
 
+// MaybeAddSlow feeds the legacy node-wide counter that the majority-open
+// fallback in canPassImmediate reads. It does not touch the per-table
+// breaker; breakers get their latency samples from RecordSlowCmd.
 func (sl *SlowLimiter) MaybeAddSlow(ts int64, cost time.Duration, cmd string, prefix string) {
-	if cost < time.Millisecond*time.Duration(atomic.LoadInt64(&SlowRefuseCostMs)) {
+	if cost < time.Millisecond*time.Duration(SlowRefuseCostMs.Load()) {
 		// while we are in some slow down state, slow write will be refused,
 		// while in half open, some history slow write will be passed to do
 		// slow check again, in this way we need check the history to
@@ -187,7 +240,7 @@ func (sl *SlowLimiter) MaybeAddSlow(ts int64, cost time.Duration, cmd string, pr
 		if cost < time.Millisecond*50 {
 			return
 		}
-		cnt := atomic.LoadInt64(&sl.slowCounter)
+		cnt := sl.slowCounter.Load()
 		if cnt < smallSlowThreshold {
 			return
 		}
@@ -199,24 +252,35 @@ func (sl *SlowLimiter) MaybeAddSlow(ts int64, cost time.Duration, cmd string, pr
 	sl.AddSlow(ts)
 }
 
+func (sl *SlowLimiter) featureCounts(cmd, prefix string) *slowFeatureCounts {
+	return sl.featureCountsByKey(cmd + " " + prefix)
+}
+
+func (sl *SlowLimiter) featureCountsByKey(feat string) *slowFeatureCounts {
+	if v, ok := sl.slowFeatures.Load(feat); ok {
+		return v.(*slowFeatureCounts)
+	}
+	fc, _ := sl.slowFeatures.LoadOrStore(feat, &slowFeatureCounts{})
+	return fc.(*slowFeatureCounts)
+}
+
 // return isslow and issmallslow
 func (sl *SlowLimiter) isHistorySlow(cmd, prefix string, sc int64, ignore10ms bool) (bool, bool) {
-	feat := cmd + " " + prefix
-	sl.mutex.RLock()
-	defer sl.mutex.RUnlock()
-	cnt, ok := sl.slow100s[feat]
-	if ok && cnt > 2 {
+	v, ok := sl.slowFeatures.Load(cmd + " " + prefix)
+	if !ok {
+		return false, false
+	}
+	fc := v.(*slowFeatureCounts)
+	if fc.slow100.Load() > 2 {
 		return true, false
 	}
 	if sc >= midSlowThreshold {
-		cnt, ok := sl.slow50s[feat]
-		if ok && cnt > 4 {
+		if fc.slow50.Load() > 4 {
 			return true, true
 		}
 	}
 	if !ignore10ms && sc >= heavySlowThreshold {
-		cnt, ok := sl.slow10s[feat]
-		if ok && cnt > 20 {
+		if fc.slow10.Load() > 20 {
 			return true, true
 		}
 	}
@@ -224,36 +288,34 @@ func (sl *SlowLimiter) isHistorySlow(cmd, prefix string, sc int64, ignore10ms bo
 }
 
 func (sl *SlowLimiter) AddSlow(ts int64) {
-	atomic.StoreInt64(&sl.lastSlowTs, ts)
+	sl.lastSlowTs.Store(ts)
 	sl.addCounterOnly()
 }
 
 func (sl *SlowLimiter) addCounterOnly() {
-	cnt := atomic.AddInt64(&sl.slowCounter, 1)
+	cnt := sl.slowCounter.Add(1)
 	if cnt > maxSlowThreshold {
-		atomic.AddInt64(&sl.slowCounter, -1)
+		sl.slowCounter.Add(-1)
 	}
 }
 
-func (sl *SlowLimiter) CanPass(ts int64, cmd string, prefix string) bool {
+// canPassImmediate is the raw, non-blocking admission check shared by
+// CanPass and Admit: it looks up the per-table breaker for prefix and
+// defers the actual Closed/Open/HalfOpen decision to it, then falls back to
+// the legacy node-wide slowCounter, which only refuses once a majority of
+// tables are already Open, preserving today's "whole node overloaded"
+// behavior for the rare case a breaker hasn't tripped yet but the node is
+// clearly struggling. It never touches the admission queue; a false here
+// just means "not right now", leaving it to the caller to decide whether to
+// fail fast or queue for a later retry.
+func (sl *SlowLimiter) canPassImmediate(ts int64, cmd, prefix string) bool {
 	if prefix == "" {
 		return true
 	}
 	if !sl.isOn() {
 		return true
 	}
-	sc := atomic.LoadInt64(&sl.slowCounter)
-	if sc < smallSlowThreshold {
-		return true
-	}
-	if ts > atomic.LoadInt64(&sl.lastSlowTs)+time.Second.Nanoseconds()*SlowHalfOpenSec {
-		return true
-	}
-	if isSlow, _ := sl.isHistorySlow(cmd, prefix, sc, false); isSlow {
-		// the write is refused, means it may slow down the raft loop if we passed,
-		// so we need add counter here even we refused it.
-		// However, we do not update timestamp for slow, so we can clear it if it become
-		// no slow while in half open state.
+	if !sl.getOrCreateBreaker(prefix).allow(ts) {
 		sl.addCounterOnly()
 		metric.SlowLimiterRefusedCnt.With(ps.Labels{
 			"table": prefix,
@@ -261,13 +323,45 @@ func (sl *SlowLimiter) CanPass(ts int64, cmd string, prefix string) bool {
 		}).Inc()
 		return false
 	}
+	if sl.openBreakerRatio() > 0.5 {
+		sc := sl.slowCounter.Load()
+		if sc >= smallSlowThreshold && ts <= sl.lastSlowTs.Load()+time.Second.Nanoseconds()*SlowHalfOpenSec.Load() {
+			sl.addCounterOnly()
+			metric.SlowLimiterRefusedCnt.With(ps.Labels{
+				"table": prefix,
+				"cmd":   cmd,
+			}).Inc()
+			return false
+		}
+	}
 	return true
 }
 
+// CanPass reports whether a write against table prefix may proceed.
+// Immediate refusals are no longer returned straight to the caller: CanPass
+// parks them in the admission queue (see slow_queue.go) for up to
+// admissionDefaultWait, so a write that arrives just as a breaker trips -
+// or just before it recovers - still has a chance to go through instead of
+// being failed outright, matching Admit's queuing contract but without
+// requiring the caller to thread a context or SLA tier through. Callers
+// that need cancellation or tier-based priority promotion should call Admit
+// directly instead.
+func (sl *SlowLimiter) CanPass(ts int64, cmd string, prefix string) bool {
+	if sl.canPassImmediate(ts, cmd, prefix) {
+		return true
+	}
+	if sl.queue == nil {
+		return false
+	}
+	priority := classifyCmdPriority(cmd)
+	return sl.queue.enqueue(context.Background(), prefix, cmd, priority, admissionDefaultWait) == nil
+}
+
 func (sl *SlowLimiter) RecordSlowCmd(cmd string, prefix string, cost time.Duration) {
 	if prefix == "" || cmd == "" {
 		return
 	}
+	sl.getOrCreateBreaker(prefix).record(time.Now().UnixNano(), cost)
 	slowKind := 0
 	if cost >= time.Millisecond*100 {
 		slowKind = 100
@@ -293,23 +387,17 @@ func (sl *SlowLimiter) RecordSlowCmd(cmd string, prefix string, cost time.Durati
 	if !sl.isOn() {
 		return
 	}
-	sc := atomic.LoadInt64(&sl.slowCounter)
+	sc := sl.slowCounter.Load()
 	if sc < smallSlowThreshold {
 		return
 	}
-	feat := cmd + " " + prefix
-	sl.mutex.Lock()
-	slow := sl.slow100s
-	if slowKind == 50 {
-		slow = sl.slow50s
-	} else if slowKind == 10 {
-		slow = sl.slow10s
-	}
-	old, ok := slow[feat]
-	if !ok {
-		old = 0
+	fc := sl.featureCounts(cmd, prefix)
+	switch slowKind {
+	case 100:
+		fc.slow100.Add(1)
+	case 50:
+		fc.slow50.Add(1)
+	case 10:
+		fc.slow10.Add(1)
 	}
-	old++
-	slow[feat] = old
-	sl.mutex.Unlock()
 }
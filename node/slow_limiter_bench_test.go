@@ -0,0 +1,56 @@
+package node
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// BenchmarkCanPass exercises the CanPass hot path under contention from many
+// goroutines hammering a handful of tables. The slow-feature lookups on this
+// path are lock-free; the per-table breaker lookup still takes breakerMutex's
+// read lock and the breaker's own mutex.
+func BenchmarkCanPass(b *testing.B) {
+	sl := NewSlowLimiter()
+	sl.TurnOn()
+	sl.slowCounter.Store(maxSlowThreshold)
+
+	prefixes := make([]string, 8)
+	for i := range prefixes {
+		prefixes[i] = "table" + strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			prefix := prefixes[i%len(prefixes)]
+			sl.CanPass(time.Now().UnixNano(), "set", prefix)
+			i++
+		}
+	})
+}
+
+// BenchmarkRecordSlowCmd exercises the RecordSlowCmd hot path, whose
+// slow-feature counter updates no longer take sl.mutex.Lock() on every slow
+// write; recording into the table breaker still locks that breaker's mutex.
+func BenchmarkRecordSlowCmd(b *testing.B) {
+	sl := NewSlowLimiter()
+	sl.TurnOn()
+	sl.slowCounter.Store(maxSlowThreshold)
+
+	prefixes := make([]string, 8)
+	for i := range prefixes {
+		prefixes[i] = "table" + strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			prefix := prefixes[i%len(prefixes)]
+			sl.RecordSlowCmd("set", prefix, time.Millisecond*60)
+			i++
+		}
+	})
+}
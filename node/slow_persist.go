@@ -0,0 +1,169 @@
+package node
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// slowSnapshotVersion is bumped whenever the on-disk/exported layout of
+// slowLimiterSnapshot changes, so Import can reject a blob it doesn't
+// understand instead of silently mis-decoding it.
+//
+// v2 added Breakers: the per-table tableBreaker state (ewma, bucket
+// counts, open/cooldown state) that CanPass/RecordSlowCmd now defer to as
+// the primary admission mechanism, previously left unsnapshotted so every
+// breaker started cold after a restart even though the legacy
+// slowCounter/slowFeatures fallback was being restored.
+const slowSnapshotVersion = 2
+
+const defaultSlowPersistInterval = time.Second * 30
+
+// slowFeatureSnapshot is the persisted form of one "cmd prefix" entry from
+// sl.slowFeatures.
+type slowFeatureSnapshot struct {
+	Feature string `json:"feature"`
+	Slow10  int64  `json:"slow10"`
+	Slow50  int64  `json:"slow50"`
+	Slow100 int64  `json:"slow100"`
+}
+
+// slowLimiterSnapshot is the versioned blob written to disk every
+// defaultSlowPersistInterval and on Stop(), and returned by Export() so a healthy peer
+// can seed a freshly-started replica via the admin API.
+type slowLimiterSnapshot struct {
+	Version     int                    `json:"version"`
+	SavedAt     int64                  `json:"saved_at"`
+	SlowCounter int64                  `json:"slow_counter"`
+	LastSlowTs  int64                  `json:"last_slow_ts"`
+	Features    []slowFeatureSnapshot  `json:"features"`
+	Breakers    []tableBreakerSnapshot `json:"breakers"`
+}
+
+// EnablePersistence turns on periodic snapshotting of the learned-slow
+// feature map to path, and immediately attempts to reload any existing
+// snapshot that is not older than staleness. It must be called before
+// Start(). A zero staleness disables the age check (every snapshot is
+// considered fresh).
+func (sl *SlowLimiter) EnablePersistence(path string, staleness time.Duration) {
+	sl.persistPath = path
+	sl.persistStaleness = staleness
+	if err := sl.loadSnapshot(); err != nil {
+		nodeLog.Infof("slow limiter snapshot not loaded from %v: %v", path, err)
+	}
+}
+
+// Export returns a versioned JSON snapshot of the current learned-slow
+// feature map plus slowCounter/lastSlowTs, suitable for an admin API to seed
+// a freshly-started replica from a healthy peer's learned state.
+func (sl *SlowLimiter) Export() ([]byte, error) {
+	return json.Marshal(sl.snapshot())
+}
+
+// Import loads the learned-slow state encoded in data, as produced by
+// Export() on another node. Features and table breakers present in data
+// overwrite the local ones; local features absent from data keep their
+// current counts.
+func (sl *SlowLimiter) Import(data []byte) error {
+	var snap slowLimiterSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	sl.restore(snap)
+	return nil
+}
+
+func (sl *SlowLimiter) snapshot() slowLimiterSnapshot {
+	snap := slowLimiterSnapshot{
+		Version:     slowSnapshotVersion,
+		SavedAt:     time.Now().Unix(),
+		SlowCounter: sl.slowCounter.Load(),
+		LastSlowTs:  sl.lastSlowTs.Load(),
+		Breakers:    sl.breakerSnapshots(),
+	}
+	sl.slowFeatures.Range(func(k, v interface{}) bool {
+		feat := k.(string)
+		fc := v.(*slowFeatureCounts)
+		snap.Features = append(snap.Features, slowFeatureSnapshot{
+			Feature: feat,
+			Slow10:  fc.slow10.Load(),
+			Slow50:  fc.slow50.Load(),
+			Slow100: fc.slow100.Load(),
+		})
+		return true
+	})
+	return snap
+}
+
+func (sl *SlowLimiter) restore(snap slowLimiterSnapshot) {
+	if snap.Version != slowSnapshotVersion {
+		nodeLog.Infof("ignoring slow limiter snapshot with unsupported version %v", snap.Version)
+		return
+	}
+	sl.slowCounter.Store(snap.SlowCounter)
+	sl.lastSlowTs.Store(snap.LastSlowTs)
+	for _, f := range snap.Features {
+		fc := sl.featureCountsByKey(f.Feature)
+		fc.slow10.Store(f.Slow10)
+		fc.slow50.Store(f.Slow50)
+		fc.slow100.Store(f.Slow100)
+	}
+	sl.restoreBreakers(snap.Breakers)
+}
+
+func (sl *SlowLimiter) loadSnapshot() error {
+	if sl.persistPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(sl.persistPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap slowLimiterSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if sl.persistStaleness > 0 {
+		age := time.Since(time.Unix(snap.SavedAt, 0))
+		if age > sl.persistStaleness {
+			nodeLog.Infof("slow limiter snapshot at %v is %v old, discarding as stale", sl.persistPath, age)
+			return nil
+		}
+	}
+	sl.restore(snap)
+	return nil
+}
+
+func (sl *SlowLimiter) saveSnapshot() error {
+	if sl.persistPath == "" {
+		return nil
+	}
+	data, err := json.Marshal(sl.snapshot())
+	if err != nil {
+		return err
+	}
+	tmp := sl.persistPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sl.persistPath)
+}
+
+func (sl *SlowLimiter) runPersist(stopC chan struct{}) {
+	defer sl.persistWg.Done()
+	ticker := time.NewTicker(defaultSlowPersistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sl.saveSnapshot(); err != nil {
+				nodeLog.Warningf("failed to persist slow limiter snapshot to %v: %v", sl.persistPath, err)
+			}
+		case <-stopC:
+			return
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package node
+
+import (
+	"container/heap"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/youzan/ZanRedisDB/metric"
+
+	ps "github.com/prometheus/client_golang/prometheus"
+)
+
+// admission priority tiers: lower value is drained first. Single-key writes
+// outrank batch/scan mutations, and a table's configured SLA tier can push a
+// request further to the front of the queue.
+const (
+	admissionPrioritySLAHigh = 0
+	admissionPriorityHigh    = 1
+	admissionPriorityNormal  = 2
+	admissionPriorityLow     = 3
+)
+
+const (
+	admissionQueueMaxLen = 4096
+	admissionDrainTick   = time.Millisecond * 50
+	admissionDefaultWait = time.Millisecond * 200
+)
+
+// classifyCmdPriority buckets a command into single-key-write vs
+// batch/scan-mutation, mirroring the cost model the raft apply loop already
+// uses to decide what is expensive.
+func classifyCmdPriority(cmd string) int {
+	switch cmd {
+	case "set", "setnx", "setex", "del", "hset", "incr", "decr", "expire":
+		return admissionPriorityHigh
+	case "mset", "plset", "hmset", "lpush", "rpush", "sadd", "zadd":
+		return admissionPriorityNormal
+	default:
+		return admissionPriorityLow
+	}
+}
+
+// admissionItem is one queued, refused write waiting for a chance to be
+// re-admitted once the node recovers from its slow state.
+type admissionItem struct {
+	table       string
+	cmd         string
+	priority    int
+	enqueueTime time.Time
+	deadline    time.Time
+	done        chan bool // true: admitted, false: expired/queue stopped
+	index       int       // maintained by container/heap
+}
+
+// admissionHeap is a min-heap ordered by (priority, enqueueTime), so within a
+// priority tier requests drain in FIFO order and age alone never promotes a
+// request across tiers.
+type admissionHeap []*admissionItem
+
+func (h admissionHeap) Len() int { return len(h) }
+func (h admissionHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].enqueueTime.Before(h[j].enqueueTime)
+}
+func (h admissionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *admissionHeap) Push(x interface{}) {
+	item := x.(*admissionItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *admissionHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// admissionQueue is the shock-absorber between a refused write and
+// ErrSlowLimiterRefused: rather than failing the request immediately, it
+// parks it here and a background drainer admits it once the node's slow
+// state has recovered enough, draining faster as sl.slowCounter decays.
+type admissionQueue struct {
+	sl *SlowLimiter
+
+	mu     sync.Mutex
+	items  admissionHeap
+	maxLen int
+	stopC  chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newAdmissionQueue(sl *SlowLimiter) *admissionQueue {
+	return &admissionQueue{
+		sl:     sl,
+		maxLen: admissionQueueMaxLen,
+	}
+}
+
+func (q *admissionQueue) start() {
+	q.stopC = make(chan struct{})
+	q.wg.Add(1)
+	go q.run()
+}
+
+func (q *admissionQueue) stop() {
+	if q.stopC == nil {
+		return
+	}
+	close(q.stopC)
+	q.wg.Wait()
+	q.mu.Lock()
+	for _, it := range q.items {
+		it.done <- false
+	}
+	q.items = nil
+	q.mu.Unlock()
+}
+
+// enqueue parks a refused write until it is admitted, its deadline (derived
+// from timeout) passes, or ctx is canceled by the redcon handler. It returns
+// nil if the write was (eventually) admitted, or ErrSlowLimiterRefused
+// otherwise.
+func (q *admissionQueue) enqueue(ctx context.Context, table, cmd string, priority int, timeout time.Duration) error {
+	now := time.Now()
+	item := &admissionItem{
+		table:       table,
+		cmd:         cmd,
+		priority:    priority,
+		enqueueTime: now,
+		deadline:    now.Add(timeout),
+		done:        make(chan bool, 1),
+	}
+
+	q.mu.Lock()
+	if len(q.items) >= q.maxLen {
+		q.mu.Unlock()
+		return ErrSlowLimiterRefused
+	}
+	heap.Push(&q.items, item)
+	depth := len(q.items)
+	q.mu.Unlock()
+
+	metric.SlowLimiterQueueDepth.With(ps.Labels{
+		"table":    table,
+		"priority": strconv.Itoa(priority),
+	}).Set(float64(depth))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var admitted bool
+	select {
+	case admitted = <-item.done:
+	case <-timer.C:
+		q.remove(item)
+	case <-ctx.Done():
+		q.remove(item)
+	}
+
+	metric.SlowLimiterQueueWaitMs.With(ps.Labels{
+		"table": table,
+	}).Observe(float64(time.Since(now).Milliseconds()))
+
+	if !admitted {
+		return ErrSlowLimiterRefused
+	}
+	return nil
+}
+
+// remove drops item from the heap if it is still queued; it is a no-op if the
+// drainer already popped (and admitted) it concurrently.
+func (q *admissionQueue) remove(item *admissionItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if item.index < 0 || item.index >= len(q.items) || q.items[item.index] != item {
+		return
+	}
+	heap.Remove(&q.items, item.index)
+}
+
+func (q *admissionQueue) run() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(admissionDrainTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			q.drain()
+		case <-q.stopC:
+			return
+		}
+	}
+}
+
+// drainRate scales with how far sl.slowCounter has decayed below
+// midSlowThreshold: the healthier the node looks, the more queued writes get
+// let through per tick.
+func (q *admissionQueue) drainRate() int {
+	sc := q.sl.slowCounter.Load()
+	switch {
+	case sc < smallSlowThreshold:
+		return 64
+	case sc < midSlowThreshold:
+		return 16
+	case sc < heavySlowThreshold:
+		return 4
+	default:
+		return 1
+	}
+}
+
+func (q *admissionQueue) drain() {
+	n := q.drainRate()
+	now := time.Now()
+	q.mu.Lock()
+	var admitted []*admissionItem
+	for i := 0; i < n && len(q.items) > 0; i++ {
+		item := heap.Pop(&q.items).(*admissionItem)
+		if now.After(item.deadline) {
+			item.done <- false
+			continue
+		}
+		admitted = append(admitted, item)
+	}
+	q.mu.Unlock()
+	for _, item := range admitted {
+		item.done <- true
+	}
+}
+
+// Admit is CanPass's ctx/tier-aware sibling, for callers that can supply a
+// cancellation context and an SLA tier that should be able to cut ahead of
+// a table's default command-class priority. Both share canPassImmediate's
+// fast path and only differ in what happens on refusal: CanPass enqueues
+// with a fixed admissionDefaultWait and priority, Admit lets the caller
+// drive the wait (via ctx) and priority (via tier) instead.
+func (sl *SlowLimiter) Admit(ctx context.Context, ts int64, cmd, prefix string, tier int) bool {
+	if sl.canPassImmediate(ts, cmd, prefix) {
+		return true
+	}
+	if sl.queue == nil {
+		return false
+	}
+	priority := classifyCmdPriority(cmd)
+	if tier < priority {
+		// a higher SLA tier (lower numeric value) lets this table cut ahead
+		// of its command class
+		priority = tier
+	}
+	return sl.queue.enqueue(ctx, prefix, cmd, priority, admissionDefaultWait) == nil
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSlowLimiterQueueDrainsRefusedWrite exercises the actual
+// refuse -> enqueue -> drain -> admit path CanPass/Admit are supposed to
+// share: trip a table's breaker so canPassImmediate refuses the write, then
+// drain the admission queue explicitly (instead of waiting out the
+// background ticker) and check the write that's blocked in CanPass comes
+// back admitted rather than refused.
+func TestSlowLimiterQueueDrainsRefusedWrite(t *testing.T) {
+	sl := NewSlowLimiter()
+	sl.TurnOn()
+
+	prefix := "test_queue_table"
+	b := sl.getOrCreateBreaker(prefix)
+	b.cooldown = time.Hour
+	b.tripOpenLocked(time.Now().UnixNano())
+
+	resultC := make(chan bool, 1)
+	go func() {
+		resultC <- sl.CanPass(time.Now().UnixNano(), "set", prefix)
+	}()
+
+	// give CanPass a moment to actually reach the enqueue call before we
+	// drain, so the drain below has something queued to admit.
+	time.Sleep(time.Millisecond * 20)
+	sl.queue.drain()
+
+	select {
+	case admitted := <-resultC:
+		if !admitted {
+			t.Fatal("expected the refused write to be admitted once the queue was drained")
+		}
+	case <-time.After(admissionDefaultWait + time.Second):
+		t.Fatal("CanPass did not return after the queue was drained")
+	}
+}
+
+// TestSlowLimiterQueueExpiresUnadmitted checks that a refused write which
+// is never drained in time comes back refused (ErrSlowLimiterRefused via a
+// false return), rather than hanging forever.
+func TestSlowLimiterQueueExpiresUnadmitted(t *testing.T) {
+	sl := NewSlowLimiter()
+	sl.TurnOn()
+
+	prefix := "test_queue_table_expire"
+	b := sl.getOrCreateBreaker(prefix)
+	b.cooldown = time.Hour
+	b.tripOpenLocked(time.Now().UnixNano())
+
+	if sl.CanPass(time.Now().UnixNano(), "set", prefix) {
+		t.Fatal("expected CanPass to refuse while the breaker stays open and the queue is never drained")
+	}
+}
@@ -0,0 +1,388 @@
+package rockredis
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/youzan/ZanRedisDB/common"
+	"github.com/youzan/ZanRedisDB/engine"
+	"github.com/youzan/ZanRedisDB/metric"
+	"github.com/youzan/gorocksdb"
+
+	ps "github.com/prometheus/client_golang/prometheus"
+)
+
+// cdcEventType is the reserved key-prefix byte for the CDC ring-buffer
+// keyspace (cdcPrefix + monotonic seq), chosen high enough that it cannot
+// collide with the collection type tags (ListType, HashType, ...).
+const cdcEventType byte = 0xF0
+
+// CDCOp identifies which list mutation produced a ChangeStream event.
+type CDCOp string
+
+const (
+	CDCOpLPush   CDCOp = "lpush"
+	CDCOpRPush   CDCOp = "rpush"
+	CDCOpLPop    CDCOp = "lpop"
+	CDCOpRPop    CDCOp = "rpop"
+	CDCOpLTrim   CDCOp = "ltrim"
+	CDCOpLTrim2  CDCOp = "ltrim2"
+	CDCOpLDel    CDCOp = "ldel"
+	CDCOpLSet    CDCOp = "lset"
+	CDCOpLInsert CDCOp = "linsert"
+	CDCOpLRem    CDCOp = "lrem"
+	CDCOpLMove   CDCOp = "lmove"
+)
+
+// CDCEvent is one published list mutation, captured from the exact point
+// where the owning write batch was durably applied.
+type CDCEvent struct {
+	Seq     int64  `json:"seq"`
+	Table   string `json:"table"`
+	Key     string `json:"key"`
+	Op      CDCOp  `json:"op"`
+	Value   []byte `json:"value,omitempty"`
+	Ts      int64  `json:"ts"`
+	HeadSeq int64  `json:"head_seq"`
+	TailSeq int64  `json:"tail_seq"`
+}
+
+// CDCFilter narrows a subscription down to the events a consumer cares
+// about; a zero-value field means "match anything" for that dimension.
+type CDCFilter struct {
+	Table     string
+	KeyPrefix string
+	Op        CDCOp
+}
+
+func (f CDCFilter) match(ev CDCEvent) bool {
+	if f.Table != "" && f.Table != ev.Table {
+		return false
+	}
+	if f.KeyPrefix != "" && !hasStringPrefix(ev.Key, f.KeyPrefix) {
+		return false
+	}
+	if f.Op != "" && f.Op != ev.Op {
+		return false
+	}
+	return true
+}
+
+func hasStringPrefix(s, prefix string) bool {
+	return len(s) >= len(prefix) && s[:len(prefix)] == prefix
+}
+
+func cdcEncodeKey(seq int64) []byte {
+	buf := make([]byte, 9)
+	buf[0] = cdcEventType
+	binary.BigEndian.PutUint64(buf[1:], uint64(seq))
+	return buf
+}
+
+func cdcDecodeKey(ek []byte) (int64, error) {
+	if len(ek) != 9 || ek[0] != cdcEventType {
+		return 0, errListKey
+	}
+	return int64(binary.BigEndian.Uint64(ek[1:])), nil
+}
+
+// changeStreams associates a RockDB instance with its ChangeStream. This
+// indirection (instead of a field on RockDB itself) lets the list mutation
+// path publish events without requiring every caller to thread a
+// *ChangeStream through; RockDB's own constructor in rockdb.go is expected
+// to call RegisterChangeStream once a ChangeStream field lands there.
+var changeStreams sync.Map // *RockDB -> *ChangeStream
+
+// RegisterChangeStream wires cs as db's ChangeStream, so list mutations on db
+// start publishing to it.
+func RegisterChangeStream(db *RockDB, cs *ChangeStream) {
+	changeStreams.Store(db, cs)
+}
+
+// UnregisterChangeStream detaches db's ChangeStream, if any.
+func UnregisterChangeStream(db *RockDB) {
+	changeStreams.Delete(db)
+}
+
+func getChangeStream(db *RockDB) *ChangeStream {
+	v, ok := changeStreams.Load(db)
+	if !ok {
+		return nil
+	}
+	return v.(*ChangeStream)
+}
+
+// ChangeStream publishes every successful list mutation so external
+// consumers can mirror list state or build secondary indexes without
+// polling. Events are persisted in a bounded ring-buffer backed by the
+// cdcEventType keyspace, so a consumer that crashes can resume tailing from
+// its last acked sequence instead of losing events.
+type ChangeStream struct {
+	db        *RockDB
+	nextSeq   int64
+	retention time.Duration
+
+	subMutex sync.Mutex
+	subs     map[*CDCIterator]struct{}
+
+	stopC chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewChangeStream creates a ChangeStream over db. retention bounds how long
+// an event is kept once no live subscriber's cursor still needs it; a zero
+// retention keeps events only as long as some subscriber hasn't acked them.
+func NewChangeStream(db *RockDB, retention time.Duration) *ChangeStream {
+	return &ChangeStream{
+		db:        db,
+		retention: retention,
+		subs:      make(map[*CDCIterator]struct{}),
+	}
+}
+
+func (cs *ChangeStream) Start() {
+	// Seed nextSeq from whatever is already persisted in the cdcEventType
+	// keyspace, otherwise a restart (rolling upgrade, crash recovery, ...)
+	// would leave it at zero and publish would reuse sequence numbers
+	// already handed out before the restart, overwriting not-yet-GC'd,
+	// not-yet-acked events and corrupting the log for resuming subscribers.
+	maxSeq, err := cs.loadMaxPersistedSeq()
+	if err != nil {
+		dbLog.Warningf("failed to load max persisted CDC seq, starting from 0: %v", err)
+	} else {
+		atomic.StoreInt64(&cs.nextSeq, maxSeq)
+	}
+
+	cs.stopC = make(chan struct{})
+	cs.wg.Add(1)
+	go cs.run()
+}
+
+// loadMaxPersistedSeq returns the highest sequence number currently stored
+// in the cdcEventType keyspace, or 0 if nothing has been persisted yet.
+func (cs *ChangeStream) loadMaxPersistedSeq() (int64, error) {
+	startKey := cdcEncodeKey(0)
+	stopKey := cdcEncodeKey(math.MaxInt64)
+	rit, err := engine.NewDBRangeIterator(cs.db.eng, startKey, stopKey, common.RangeClose, true)
+	if err != nil {
+		return 0, err
+	}
+	defer rit.Close()
+	if !rit.Valid() {
+		return 0, nil
+	}
+	return cdcDecodeKey(rit.RefKey())
+}
+
+func (cs *ChangeStream) Stop() {
+	if cs.stopC != nil {
+		close(cs.stopC)
+		cs.wg.Wait()
+		cs.stopC = nil
+	}
+}
+
+func (cs *ChangeStream) run() {
+	defer cs.wg.Done()
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cs.gc()
+		case <-cs.stopC:
+			return
+		}
+	}
+}
+
+// publish persists ev under the next monotonic sequence number; it is called
+// from the list mutation path right after db.eng.Write(db.defaultWriteOpts,
+// wb) succeeds. Subscribers are not notified: they see the event on their
+// next Next() call.
+func (cs *ChangeStream) publish(ev CDCEvent) error {
+	seq := atomic.AddInt64(&cs.nextSeq, 1)
+	ev.Seq = seq
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	wb.Put(cdcEncodeKey(seq), data)
+	if err := cs.db.eng.Write(cs.db.defaultWriteOpts, wb); err != nil {
+		return err
+	}
+	metric.CDCPublishTotal.With(ps.Labels{"op": string(ev.Op)}).Inc()
+	return nil
+}
+
+// Subscribe returns an Iterator starting at fromSeq (0 means "from the
+// oldest retained event"), filtered server-side by filter.
+func (cs *ChangeStream) Subscribe(fromSeq int64, filter CDCFilter) *CDCIterator {
+	it := &CDCIterator{
+		cs:     cs,
+		cursor: fromSeq,
+		filter: filter,
+	}
+	cs.subMutex.Lock()
+	cs.subs[it] = struct{}{}
+	cs.subMutex.Unlock()
+	return it
+}
+
+func (cs *ChangeStream) unsubscribe(it *CDCIterator) {
+	cs.subMutex.Lock()
+	delete(cs.subs, it)
+	cs.subMutex.Unlock()
+}
+
+// oldestNeededSeq returns the smallest cursor among live subscribers, or -1
+// if there are none (in which case gc falls back to the retention window
+// alone).
+func (cs *ChangeStream) oldestNeededSeq() int64 {
+	cs.subMutex.Lock()
+	defer cs.subMutex.Unlock()
+	min := int64(-1)
+	for it := range cs.subs {
+		c := atomic.LoadInt64(&it.cursor)
+		if min < 0 || c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// gc deletes events older than the oldest live subscriber's cursor, or
+// older than the retention window if there are no subscribers, whichever is
+// more conservative.
+func (cs *ChangeStream) gc() {
+	oldest := cs.oldestNeededSeq()
+	startKey := cdcEncodeKey(0)
+	stopKey := cdcEncodeKey(atomic.LoadInt64(&cs.nextSeq) + 1)
+	rit, err := engine.NewDBRangeIterator(cs.db.eng, startKey, stopKey, common.RangeClose, false)
+	if err != nil {
+		return
+	}
+	defer rit.Close()
+
+	cutoff := time.Now().Add(-cs.retention)
+	var deleteUpto []byte
+	lag := 0
+	for ; rit.Valid(); rit.Next() {
+		seq, err := cdcDecodeKey(rit.RefKey())
+		if err != nil {
+			continue
+		}
+		if oldest >= 0 && seq >= oldest {
+			break
+		}
+		var ev CDCEvent
+		if err := json.Unmarshal(rit.Value(), &ev); err != nil {
+			continue
+		}
+		if cs.retention > 0 && time.Unix(0, ev.Ts).After(cutoff) {
+			break
+		}
+		deleteUpto = cdcEncodeKey(seq + 1)
+		lag++
+	}
+	if deleteUpto != nil {
+		wb := gorocksdb.NewWriteBatch()
+		wb.DeleteRange(startKey, deleteUpto)
+		cs.db.eng.Write(cs.db.defaultWriteOpts, wb)
+		wb.Destroy()
+	}
+	metric.CDCLagEvents.Set(float64(lag))
+}
+
+// publishListChange builds and publishes a CDCEvent for a list mutation on
+// db, captured from the exact point its write batch was durably applied. It
+// is a no-op if db has no ChangeStream registered.
+func (db *RockDB) publishListChange(op CDCOp, table, key []byte, headSeq, tailSeq int64, value []byte) {
+	cs := getChangeStream(db)
+	if cs == nil {
+		return
+	}
+	ev := CDCEvent{
+		Table:   string(table),
+		Key:     string(key),
+		Op:      op,
+		Value:   value,
+		Ts:      time.Now().UnixNano(),
+		HeadSeq: headSeq,
+		TailSeq: tailSeq,
+	}
+	if err := cs.publish(ev); err != nil {
+		dbLog.Warningf("failed to publish list change event for %v: %v", string(key), err)
+	}
+}
+
+func lastPushedValue(args [][]byte) []byte {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[len(args)-1]
+}
+
+// CDCIterator is a resumable tailing cursor over a ChangeStream. Following
+// the "live log reader" convention: Next() returning (false, nil) means "no
+// more events right now, call again" so a single goroutine can tail in a
+// tight loop with a short sleep, while (false, io.EOF) means the stream (or
+// this subscription) was closed.
+type CDCIterator struct {
+	cs     *ChangeStream
+	cursor int64
+	filter CDCFilter
+	closed int32
+}
+
+// Next returns the next event whose sequence is > the iterator's cursor and
+// matches its filter, advancing the cursor past it. ok is false with a nil
+// error when there is currently nothing new to read.
+func (it *CDCIterator) Next() (ev CDCEvent, ok bool, err error) {
+	if atomic.LoadInt32(&it.closed) != 0 {
+		return CDCEvent{}, false, io.EOF
+	}
+	startKey := cdcEncodeKey(it.cursor + 1)
+	stopKey := cdcEncodeKey(atomic.LoadInt64(&it.cs.nextSeq) + 1)
+	rit, rerr := engine.NewDBRangeIterator(it.cs.db.eng, startKey, stopKey, common.RangeClose, false)
+	if rerr != nil {
+		return CDCEvent{}, false, rerr
+	}
+	defer rit.Close()
+	for ; rit.Valid(); rit.Next() {
+		seq, derr := cdcDecodeKey(rit.RefKey())
+		if derr != nil {
+			continue
+		}
+		var cand CDCEvent
+		if err := json.Unmarshal(rit.Value(), &cand); err != nil {
+			continue
+		}
+		atomic.StoreInt64(&it.cursor, seq)
+		if !it.filter.match(cand) {
+			continue
+		}
+		return cand, true, nil
+	}
+	return CDCEvent{}, false, nil
+}
+
+// Ack records that the caller has durably processed everything up to and
+// including seq, allowing the garbage collector to reclaim it.
+func (it *CDCIterator) Ack(seq int64) {
+	atomic.StoreInt64(&it.cursor, seq)
+}
+
+// Close ends the subscription; a subsequent Next() returns (false, io.EOF).
+func (it *CDCIterator) Close() {
+	if atomic.CompareAndSwapInt32(&it.closed, 0, 1) {
+		it.cs.unsubscribe(it)
+	}
+}
@@ -0,0 +1,616 @@
+package rockredis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/youzan/ZanRedisDB/common"
+	"github.com/youzan/ZanRedisDB/engine"
+)
+
+// RDB opcodes, as defined by the Redis RDB file format.
+const (
+	rdbOpcodeSlotInfo     = 0xF4
+	rdbOpcodeFunction2    = 0xF5
+	rdbOpcodeModuleAux    = 0xF7
+	rdbOpcodeIdle         = 0xF8
+	rdbOpcodeFreq         = 0xF9
+	rdbOpcodeAux          = 0xFA
+	rdbOpcodeResizeDB     = 0xFB
+	rdbOpcodeExpiretimeMs = 0xFC
+	rdbOpcodeExpiretime   = 0xFD
+	rdbOpcodeSelectDB     = 0xFE
+	rdbOpcodeEOF          = 0xFF
+)
+
+// RDB value type tags. Only List is backed by a real storage engine
+// (t_list.go) and round-trips through DumpRDB/LoadRDB, and only via the
+// legacy flat-array RDB_TYPE_LIST encoding (see rdb_list.go); String, Set,
+// ZSET and Hash are recognized so their values can be parsed and skipped by
+// genericSkipHandler instead of aborting the whole load. RDB_TYPE_LIST_*
+// quicklist variants are listed here for documentation (they are the types
+// a real Redis 3.2+ instance actually emits for a list) but have no
+// registered handler: their payloads are ziplist/listpack-encoded nodes,
+// not a flat element array, and this tree has no codec for either.
+const (
+	RDB_TYPE_STRING           = 0
+	RDB_TYPE_LIST             = 1
+	RDB_TYPE_SET              = 2
+	RDB_TYPE_ZSET             = 3
+	RDB_TYPE_HASH             = 4
+	RDB_TYPE_LIST_QUICKLIST   = 10
+	RDB_TYPE_LIST_QUICKLIST_2 = 18
+)
+
+// length-encoding markers, top two bits of the first length byte.
+const (
+	rdb6BitLen      = 0
+	rdb14BitLen     = 1
+	rdb32or64BitLen = 2
+	rdbEncVal       = 3
+
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+)
+
+// special string encodings used under the rdbEncVal length marker.
+const (
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+const (
+	rdbMagic   = "REDIS"
+	rdbVersion = "0011"
+)
+
+var errRDBMagic = errors.New("invalid rdb magic header")
+var errRDBLen = errors.New("invalid rdb length encoding")
+var errRDBType = errors.New("unsupported rdb value type")
+var errRDBChecksum = errors.New("rdb checksum mismatch")
+
+// crc64JonesTable is the lookup table for the CRC-64 variant (poly 0xad93d23594c935a9, reflected)
+// Redis uses to checksum RDB files.
+var crc64JonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// crc64JonesUpdate runs the Jones CRC64 LFSR (init=0, xorout=0) over p using
+// tab, picking up from the running state crc. Go's stdlib crc64.Update/
+// Checksum always apply the ISO/ECMA convention's implicit crc=^crc
+// complement at both ends of the computation; Jones uses neither, so the
+// update loop is reimplemented here instead of calling crc64.Update —
+// verified against the reference check value crc64/jones("123456789") ==
+// 0xe9c6d914c4b8d9ca.
+func crc64JonesUpdate(crc uint64, tab *crc64.Table, p []byte) uint64 {
+	for _, v := range p {
+		crc = tab[byte(crc)^v] ^ (crc >> 8)
+	}
+	return crc
+}
+
+// rdbTypeHandler lets each collection type (list, hash, set, zset, string)
+// plug its own encode/decode logic into the shared Dump/Load framework.
+// List is the only type with a real storage engine in this tree, so it's
+// the only one with a genuine dump(); Hash/Set/ZSet/String are registered
+// with a genericSkipHandler that parses and discards their value so
+// LoadRDB can still walk past them. Once one of those types grows the
+// equivalent of t_list.go's lEncodeListKey/parseListMeta pair, its own
+// rdbTypeHandler should replace the skip handler registered for it below.
+type rdbTypeHandler interface {
+	// rdbType returns the RDB value type tag this handler writes.
+	rdbType() byte
+	// dump writes the value stored under the user key to w, resolving its
+	// table/rk itself; it returns false if there is nothing to write.
+	dump(db *RockDB, w *rdbWriter, key []byte) (bool, error)
+	// load reads one value of this handler's type for key from r and applies it.
+	load(db *RockDB, r *rdbReader, key []byte, expireAt int64) error
+}
+
+var rdbHandlers = map[byte]rdbTypeHandler{}
+
+func registerRDBHandler(h rdbTypeHandler) {
+	rdbHandlers[h.rdbType()] = h
+}
+
+func init() {
+	registerRDBHandler(&listRDBHandler{})
+}
+
+// genericSkipHandler parses and discards a value of a collection type this
+// RockDB snapshot has no dedicated storage engine for yet (Hash, Set, ZSet
+// and String don't have a t_*.go file the way List has t_list.go). It lets
+// LoadRDB walk past these keys instead of aborting the whole stream on the
+// very first non-list key, matching LoadRDB's own doc comment; once a real
+// storage engine for a given type lands, its own rdbTypeHandler should
+// replace the corresponding entry registered here.
+type genericSkipHandler struct {
+	typ  byte
+	skip func(rr *rdbReader) error
+}
+
+func (h *genericSkipHandler) rdbType() byte { return h.typ }
+
+func (h *genericSkipHandler) dump(db *RockDB, w *rdbWriter, key []byte) (bool, error) {
+	return false, errRDBType
+}
+
+func (h *genericSkipHandler) load(db *RockDB, r *rdbReader, key []byte, expireAt int64) error {
+	return h.skip(r)
+}
+
+func skipRDBString(rr *rdbReader) error {
+	_, err := rr.readString()
+	return err
+}
+
+func skipRDBStrings(rr *rdbReader) error {
+	n, _, err := rr.readLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := rr.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func skipRDBStringPairs(rr *rdbReader) error {
+	n, _, err := rr.readLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := rr.readString(); err != nil {
+			return err
+		}
+		if _, err := rr.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func skipRDBZSet(rr *rdbReader) error {
+	n, _, err := rr.readLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := rr.readString(); err != nil {
+			return err
+		}
+		if _, err := rr.readDouble(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDouble decodes the "old" RDB zset-score encoding: a single length
+// byte that is either a literal byte count for an ASCII float, or one of
+// the three sentinels (253 = NaN, 254 = +Inf, 255 = -Inf).
+func (rr *rdbReader) readDouble() (float64, error) {
+	n, err := rr.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 255:
+		return math.Inf(-1), nil
+	case 254:
+		return math.Inf(1), nil
+	case 253:
+		return math.NaN(), nil
+	default:
+		buf, err := rr.readFull(int(n))
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseFloat(string(buf), 64)
+	}
+}
+
+func init() {
+	registerRDBHandler(&genericSkipHandler{typ: RDB_TYPE_STRING, skip: skipRDBString})
+	registerRDBHandler(&genericSkipHandler{typ: RDB_TYPE_SET, skip: skipRDBStrings})
+	registerRDBHandler(&genericSkipHandler{typ: RDB_TYPE_ZSET, skip: skipRDBZSet})
+	registerRDBHandler(&genericSkipHandler{typ: RDB_TYPE_HASH, skip: skipRDBStringPairs})
+}
+
+// rdbWriter wraps an io.Writer with a running CRC64 so DumpRDB can append the
+// trailing checksum without buffering the whole stream in memory.
+type rdbWriter struct {
+	w   io.Writer
+	crc uint64
+}
+
+func newRDBWriter(w io.Writer) *rdbWriter {
+	return &rdbWriter{w: w}
+}
+
+func (rw *rdbWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	rw.crc = crc64JonesUpdate(rw.crc, crc64JonesTable, p[:n])
+	return n, err
+}
+
+func (rw *rdbWriter) writeByte(b byte) error {
+	_, err := rw.Write([]byte{b})
+	return err
+}
+
+// writeLength encodes n using the 6/14/32/64-bit RDB length encoding.
+func (rw *rdbWriter) writeLength(n uint64) error {
+	switch {
+	case n < 1<<6:
+		return rw.writeByte(byte(n))
+	case n < 1<<14:
+		buf := []byte{byte(rdb14BitLen<<6) | byte(n>>8), byte(n)}
+		_, err := rw.Write(buf)
+		return err
+	case n <= 0xFFFFFFFF:
+		buf := make([]byte, 5)
+		buf[0] = rdb32BitLen
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		_, err := rw.Write(buf)
+		return err
+	default:
+		buf := make([]byte, 9)
+		buf[0] = rdb64BitLen
+		binary.BigEndian.PutUint64(buf[1:], n)
+		_, err := rw.Write(buf)
+		return err
+	}
+}
+
+// writeString writes a length-prefixed string. No attempt is made to emit
+// the int or LZF encodings on write: a plain length-prefixed string is always
+// valid RDB and real redis-cli/DEBUG RELOAD happily reads it back.
+func (rw *rdbWriter) writeString(s []byte) error {
+	if err := rw.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := rw.Write(s)
+	return err
+}
+
+func (rw *rdbWriter) writeHeader() error {
+	_, err := rw.Write([]byte(rdbMagic + rdbVersion))
+	return err
+}
+
+func (rw *rdbWriter) writeFooter() error {
+	if err := rw.writeByte(rdbOpcodeEOF); err != nil {
+		return err
+	}
+	crc := rw.crc
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, crc)
+	_, err := rw.w.Write(buf)
+	return err
+}
+
+// rdbReader wraps a bufio.Reader with a running CRC64 so LoadRDB can validate
+// the trailing checksum after consuming the whole stream.
+type rdbReader struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func newRDBReader(r io.Reader) *rdbReader {
+	return &rdbReader{r: bufio.NewReader(r)}
+}
+
+func (rr *rdbReader) readByte() (byte, error) {
+	b, err := rr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	rr.crc = crc64JonesUpdate(rr.crc, crc64JonesTable, []byte{b})
+	return b, nil
+}
+
+func (rr *rdbReader) readFull(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, buf); err != nil {
+		return nil, err
+	}
+	rr.crc = crc64JonesUpdate(rr.crc, crc64JonesTable, buf)
+	return buf, nil
+}
+
+// readLength decodes the 6/14/32/64-bit RDB length encoding, returning the
+// length plus whether the value is one of the special (int/LZF) encodings.
+func (rr *rdbReader) readLength() (uint64, bool, error) {
+	first, err := rr.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	kind := (first & 0xC0) >> 6
+	switch kind {
+	case rdb6BitLen:
+		return uint64(first & 0x3F), false, nil
+	case rdb14BitLen:
+		next, err := rr.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case rdb32or64BitLen:
+		if first == rdb32BitLen {
+			buf, err := rr.readFull(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		}
+		if first == rdb64BitLen {
+			buf, err := rr.readFull(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, errRDBLen
+	case rdbEncVal:
+		return uint64(first & 0x3F), true, nil
+	}
+	return 0, false, errRDBLen
+}
+
+// readString decodes a length-prefixed string, including the int8/16/32 and
+// LZF special encodings.
+func (rr *rdbReader) readString() ([]byte, error) {
+	length, isEnc, err := rr.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !isEnc {
+		return rr.readFull(int(length))
+	}
+	switch length {
+	case rdbEncInt8:
+		b, err := rr.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(itoa(int64(int8(b)))), nil
+	case rdbEncInt16:
+		buf, err := rr.readFull(2)
+		if err != nil {
+			return nil, err
+		}
+		v := int16(binary.LittleEndian.Uint16(buf))
+		return []byte(itoa(int64(v))), nil
+	case rdbEncInt32:
+		buf, err := rr.readFull(4)
+		if err != nil {
+			return nil, err
+		}
+		v := int32(binary.LittleEndian.Uint32(buf))
+		return []byte(itoa(int64(v))), nil
+	case rdbEncLZF:
+		clen, _, err := rr.readLength()
+		if err != nil {
+			return nil, err
+		}
+		ulen, _, err := rr.readLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := rr.readFull(int(clen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(ulen))
+	}
+	return nil, errRDBLen
+}
+
+func itoa(v int64) string {
+	return fmtInt(v)
+}
+
+// fmtInt formats v in decimal; RDB int-encoded strings are always small
+// decimal numbers.
+func fmtInt(v int64) string {
+	if v == 0 {
+		return "0"
+	}
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	var buf [20]byte
+	pos := len(buf)
+	for v > 0 {
+		pos--
+		buf[pos] = byte('0' + v%10)
+		v /= 10
+	}
+	if neg {
+		pos--
+		buf[pos] = '-'
+	}
+	return string(buf[pos:])
+}
+
+// lzfDecompress implements the LZF decompression algorithm used by Redis's
+// string encoding (opcode 0xC3): a literal run copies bytes verbatim, a back
+// reference copies len bytes starting ref bytes behind the output cursor.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	i := 0
+	for i < len(in) {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errors.New("lzf: literal run overruns input")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errors.New("lzf: truncated length byte")
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errors.New("lzf: truncated reference byte")
+		}
+		ref := len(out) - ((ctrl&0x1f)<<8 | int(in[i])) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("lzf: back reference out of range")
+		}
+		for n := 0; n < length+2; n++ {
+			out = append(out, out[ref+n])
+		}
+	}
+	return out, nil
+}
+
+// DumpRDB writes the given keys (or every list key, if keys is empty) in
+// Redis RDB format to w, so operators can bulk-migrate data out of
+// ZanRedisDB into a real Redis instance.
+func (db *RockDB) DumpRDB(w io.Writer, keys ...[]byte) error {
+	rw := newRDBWriter(w)
+	if err := rw.writeHeader(); err != nil {
+		return err
+	}
+	// a single logical db is always selected; ZanRedisDB does not multiplex
+	// multiple redis DBs on one RockDB instance.
+	if err := rw.writeByte(rdbOpcodeSelectDB); err != nil {
+		return err
+	}
+	if err := rw.writeLength(0); err != nil {
+		return err
+	}
+
+	handler := rdbHandlers[RDB_TYPE_LIST]
+	if len(keys) == 0 {
+		rit, err := engine.NewDBRangeIterator(db.eng, lEncodeMinKey(), lEncodeMaxKey(), common.RangeROpen, false)
+		if err != nil {
+			return err
+		}
+		for ; rit.Valid(); rit.Next() {
+			if k, derr := lDecodeMetaKey(rit.RefKey()); derr == nil {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+		}
+		rit.Close()
+	}
+	for _, key := range keys {
+		// TTL export is left for a follow-up: the list path does not yet
+		// expose a remaining-TTL accessor the way collExpire/collPersist
+		// expose setting/clearing one.
+		if _, err := handler.dump(db, rw, key); err != nil {
+			return err
+		}
+	}
+	return rw.writeFooter()
+}
+
+// LoadRDB reads an RDB stream produced by DumpRDB (or, for list keys encoded
+// as the legacy RDB_TYPE_LIST, by a real Redis instance) and replays every
+// list-type record into this RockDB instance. Records of other types are
+// skipped (their handlers are not implemented yet) rather than aborting the
+// whole load; a list record encoded as RDB_TYPE_LIST_QUICKLIST(_2) - what a
+// real Redis 3.2+ instance actually emits - has no registered handler and
+// aborts the load with errRDBType rather than being misparsed.
+func (db *RockDB) LoadRDB(r io.Reader) error {
+	rr := newRDBReader(r)
+	magic, err := rr.readFull(len(rdbMagic) + 4)
+	if err != nil {
+		return err
+	}
+	if string(magic[:len(rdbMagic)]) != rdbMagic {
+		return errRDBMagic
+	}
+
+	var pendingExpireAt int64 = -1
+	for {
+		opcode, err := rr.readByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case rdbOpcodeEOF:
+			wantCrc := rr.crc
+			gotBuf := make([]byte, 8)
+			if _, err := io.ReadFull(rr.r, gotBuf); err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					// some producers omit the trailing checksum (rdbchecksum no)
+					return nil
+				}
+				return err
+			}
+			gotCrc := binary.LittleEndian.Uint64(gotBuf)
+			if gotCrc != 0 && gotCrc != wantCrc {
+				return errRDBChecksum
+			}
+			return nil
+		case rdbOpcodeSelectDB:
+			if _, _, err := rr.readLength(); err != nil {
+				return err
+			}
+		case rdbOpcodeResizeDB:
+			if _, _, err := rr.readLength(); err != nil {
+				return err
+			}
+			if _, _, err := rr.readLength(); err != nil {
+				return err
+			}
+		case rdbOpcodeAux:
+			if _, err := rr.readString(); err != nil {
+				return err
+			}
+			if _, err := rr.readString(); err != nil {
+				return err
+			}
+		case rdbOpcodeExpiretimeMs:
+			buf, err := rr.readFull(8)
+			if err != nil {
+				return err
+			}
+			pendingExpireAt = int64(binary.LittleEndian.Uint64(buf))
+		case rdbOpcodeExpiretime:
+			buf, err := rr.readFull(4)
+			if err != nil {
+				return err
+			}
+			pendingExpireAt = int64(binary.LittleEndian.Uint32(buf)) * 1000
+		default:
+			key, err := rr.readString()
+			if err != nil {
+				return err
+			}
+			h, ok := rdbHandlers[opcode]
+			if !ok {
+				return errRDBType
+			}
+			if err := h.load(db, rr, key, pendingExpireAt); err != nil {
+				return err
+			}
+			pendingExpireAt = -1
+		}
+	}
+}
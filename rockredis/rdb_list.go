@@ -0,0 +1,119 @@
+package rockredis
+
+import (
+	"time"
+)
+
+// listRDBHandler implements rdbTypeHandler for the List collection, walking
+// lEncodeListKey(table, rk, headSeq..tailSeq) on dump and replaying a batch
+// of lpush-equivalent writes on load.
+//
+// It only speaks RDB_TYPE_LIST (type 1), the legacy flat-array encoding:
+// real Redis 3.2+ always emits lists as RDB_TYPE_LIST_QUICKLIST (type 10,
+// ziplist-encoded nodes) or RDB_TYPE_LIST_QUICKLIST_2 (type 18,
+// listpack-encoded nodes), neither of which is a flat count + N
+// length-prefixed strings the way this handler reads/writes - those are
+// node-structured payloads this tree has no ziplist/listpack codec for.
+// Registering this handler under type 18 (as an earlier version of this
+// file did) would silently misparse a real quicklist2 payload as flat
+// elements, corrupting data instead of failing loudly. Until a real
+// ziplist/listpack decoder lands, round-tripping through DumpRDB/LoadRDB on
+// this tree is supported; round-tripping a dump captured from an actual
+// Redis 3.2+ instance is not, and LoadRDB will correctly error out on a
+// type 10/18 record via its "no handler for opcode" path rather than
+// accepting and corrupting it.
+type listRDBHandler struct{}
+
+func (h *listRDBHandler) rdbType() byte { return RDB_TYPE_LIST }
+
+// dump writes key's list to w as a single RDB_TYPE_LIST record: the key
+// name, followed by the element count and each element as a
+// length-prefixed string. It returns false (without writing anything) if key
+// is not a list or does not exist.
+func (h *listRDBHandler) dump(db *RockDB, rw *rdbWriter, key []byte) (bool, error) {
+	ts := time.Now().UnixNano()
+	keyInfo, headSeq, tailSeq, size, _, err := db.lHeaderAndMeta(ts, key, true)
+	if err != nil {
+		return false, err
+	}
+	if keyInfo.IsNotExistOrExpired() || size <= 0 {
+		return false, nil
+	}
+	table := keyInfo.Table
+	rk := keyInfo.VerKey
+
+	if err := rw.writeByte(h.rdbType()); err != nil {
+		return false, err
+	}
+	if err := rw.writeString(key); err != nil {
+		return false, err
+	}
+	if err := rw.writeLength(uint64(size)); err != nil {
+		return false, err
+	}
+	for seq := headSeq; seq <= tailSeq; seq++ {
+		ek := lEncodeListKey(table, rk, seq)
+		v, err := db.eng.GetBytesNoLock(db.defaultReadOpts, ek)
+		if err != nil {
+			return false, err
+		}
+		if err := rw.writeString(v); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// load reads a RDB_TYPE_LIST record's element count and values, pre-allocates
+// headSeq/tailSeq around
+// listInitialSeq and batch-writes every item plus a single lSetMeta, exactly
+// like a bulk RPush would.
+func (h *listRDBHandler) load(db *RockDB, rr *rdbReader, key []byte, expireAtMs int64) error {
+	count, _, err := rr.readLength()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	ts := time.Now().UnixNano()
+	wb := db.wb
+	defer wb.Clear()
+
+	keyInfo, err := db.prepareCollKeyForWrite(ts, ListType, key, nil)
+	if err != nil {
+		return err
+	}
+	table := keyInfo.Table
+	rk := keyInfo.VerKey
+
+	headSeq := listInitialSeq
+	tailSeq := listInitialSeq + int64(count) - 1
+	for i := uint64(0); i < count; i++ {
+		v, err := rr.readString()
+		if err != nil {
+			return err
+		}
+		ek := lEncodeListKey(table, rk, headSeq+int64(i))
+		wb.Put(ek, v)
+	}
+	if !keyInfo.Expired {
+		db.IncrTableKeyCount(table, 1, wb)
+	}
+	if _, err := db.lSetMeta(key, keyInfo.OldHeader, headSeq, tailSeq, ts, wb); err != nil {
+		return err
+	}
+	if err := db.eng.Write(db.defaultWriteOpts, wb); err != nil {
+		return err
+	}
+	if expireAtMs > 0 {
+		durationSec := expireAtMs/1000 - time.Now().Unix()
+		if durationSec > 0 {
+			if _, err := db.collExpire(ts, ListType, key, durationSec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package rockredis
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"time"
@@ -27,6 +28,20 @@ const (
 	listInitialSeq int64 = listMinSeq + (listMaxSeq-listMinSeq)/2
 )
 
+// ListHeadSeq and ListTailSeq select which end of a list LMove pops from or
+// pushes to; they are the exported form of the head/tail markers already
+// used internally by push/pop/trim.
+const (
+	ListHeadSeq = listHeadSeq
+	ListTailSeq = listTailSeq
+)
+
+// LInsert "where" markers, mirroring Redis' BEFORE/AFTER.
+const (
+	ListInsertBefore int64 = 1
+	ListInsertAfter  int64 = 2
+)
+
 var errLMetaKey = errors.New("invalid lmeta key")
 var errListKey = errors.New("invalid list key")
 var errListSeq = errors.New("invalid list sequence, overflow")
@@ -253,6 +268,13 @@ func (db *RockDB) lpush(ts int64, key []byte, whereSeq int64, args ...[]byte) (i
 		return 0, err
 	}
 	err = db.eng.Write(db.defaultWriteOpts, wb)
+	if err == nil {
+		op := CDCOpLPush
+		if whereSeq == listTailSeq {
+			op = CDCOpRPush
+		}
+		db.publishListChange(op, table, key, headSeq, tailSeq, lastPushedValue(args))
+	}
 
 	newNum := int64(size) + int64(pushCnt)
 	db.topLargeCollKeys.Update(key, int(newNum))
@@ -331,6 +353,13 @@ func (db *RockDB) lpop(ts int64, key []byte, whereSeq int64) ([]byte, error) {
 	}
 	db.topLargeCollKeys.Update(key, int(newNum))
 	err = db.eng.Write(db.defaultWriteOpts, wb)
+	if err == nil {
+		op := CDCOpLPop
+		if whereSeq == listTailSeq {
+			op = CDCOpRPop
+		}
+		db.publishListChange(op, table, key, headSeq, tailSeq, value)
+	}
 	return value, err
 }
 
@@ -361,8 +390,10 @@ func (db *RockDB) ltrim2(ts int64, key []byte, startP, stopP int64) error {
 		stop = llen + stop
 	}
 	newLen := int64(0)
+	wholeDeleted := false
 	// whole list deleted
 	if start >= llen || start > stop {
+		wholeDeleted = true
 		db.lDelete(ts, key, db.wb)
 	} else {
 		if start < 0 {
@@ -407,7 +438,15 @@ func (db *RockDB) ltrim2(ts int64, key []byte, startP, stopP int64) error {
 	}
 
 	db.topLargeCollKeys.Update(key, int(newLen))
-	return db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.eng.Write(db.defaultWriteOpts, wb)
+	if err == nil {
+		if wholeDeleted {
+			db.publishListChange(CDCOpLDel, table, key, 0, 0, nil)
+		} else {
+			db.publishListChange(CDCOpLTrim2, table, key, headSeq+start, headSeq+stop, nil)
+		}
+	}
+	return err
 }
 
 func (db *RockDB) ltrim(ts int64, key []byte, trimSize, whereSeq int64) (int64, error) {
@@ -476,6 +515,9 @@ func (db *RockDB) ltrim(ts int64, key []byte, trimSize, whereSeq int64) (int64,
 
 	db.topLargeCollKeys.Update(key, int(newLen))
 	err = db.eng.Write(db.defaultWriteOpts, wb)
+	if err == nil {
+		db.publishListChange(CDCOpLTrim, table, key, headSeq, tailSeq, nil)
+	}
 	return trimEndSeq - trimStartSeq + 1, err
 }
 
@@ -689,6 +731,9 @@ func (db *RockDB) LSet(ts int64, key []byte, index int64, value []byte) error {
 	db.lSetMeta(key, keyInfo.OldHeader, headSeq, tailSeq, ts, wb)
 	wb.Put(sk, value)
 	err = db.CommitBatchWrite()
+	if err == nil {
+		db.publishListChange(CDCOpLSet, table, key, headSeq, tailSeq, value)
+	}
 	return err
 }
 
@@ -759,14 +804,398 @@ func (db *RockDB) RPush(ts int64, key []byte, args ...[]byte) (int64, error) {
 	return db.lpush(ts, key, listTailSeq, args...)
 }
 
+// LInsert inserts value immediately before (ListInsertBefore) or after
+// (ListInsertAfter) the first occurrence of pivot, scanning from the head.
+// It returns the new list length, -1 if pivot is not found, or 0 if key
+// does not exist.
+//
+// Elements are packed at contiguous seq values between headSeq and
+// tailSeq, so instead of rewriting the whole list we shift only the
+// shorter side: if pivot sits closer to the head we shift the head-ward
+// block down by one seq (mirroring how lpush extends headSeq), otherwise
+// we shift the tail-ward block up by one (mirroring rpush). This keeps
+// the cost O(min(index, len-index)) and preserves the lastSeq+1 == seq
+// invariant that fixListKey checks.
+func (db *RockDB) LInsert(ts int64, key []byte, where int64, pivot, value []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+	if where != ListInsertBefore && where != ListInsertAfter {
+		return 0, errListIndex
+	}
+
+	keyInfo, headSeq, tailSeq, size, _, err := db.lHeaderAndMeta(ts, key, false)
+	if err != nil {
+		return 0, err
+	}
+	if keyInfo.IsNotExistOrExpired() || size == 0 {
+		return 0, nil
+	}
+	table := keyInfo.Table
+	rk := keyInfo.VerKey
+
+	startKey := lEncodeListKey(table, rk, headSeq)
+	stopKey := lEncodeListKey(table, rk, tailSeq)
+	rit, err := engine.NewDBRangeIterator(db.eng, startKey, stopKey, common.RangeClose, false)
+	if err != nil {
+		return 0, err
+	}
+	foundSeq := int64(-1)
+	for ; rit.Valid(); rit.Next() {
+		if bytes.Equal(rit.Value(), pivot) {
+			if _, _, seq, derr := lDecodeListKey(rit.RefKey()); derr == nil {
+				foundSeq = seq
+			}
+			break
+		}
+	}
+	rit.Close()
+	if foundSeq < 0 {
+		return -1, nil
+	}
+
+	wb := db.wb
+	defer wb.Clear()
+
+	var insertSeq int64
+	if foundSeq-headSeq <= tailSeq-foundSeq {
+		shiftEnd := foundSeq - 1
+		if where == ListInsertAfter {
+			shiftEnd = foundSeq
+		}
+		for seq := headSeq; seq <= shiftEnd; seq++ {
+			v, verr := db.eng.GetBytesNoLock(db.defaultReadOpts, lEncodeListKey(table, rk, seq))
+			if verr != nil {
+				return 0, verr
+			}
+			wb.Put(lEncodeListKey(table, rk, seq-1), v)
+		}
+		insertSeq = shiftEnd
+		headSeq--
+	} else {
+		shiftStart := foundSeq
+		if where == ListInsertAfter {
+			shiftStart = foundSeq + 1
+		}
+		for seq := tailSeq; seq >= shiftStart; seq-- {
+			v, verr := db.eng.GetBytesNoLock(db.defaultReadOpts, lEncodeListKey(table, rk, seq))
+			if verr != nil {
+				return 0, verr
+			}
+			wb.Put(lEncodeListKey(table, rk, seq+1), v)
+		}
+		insertSeq = shiftStart
+		tailSeq++
+	}
+	wb.Put(lEncodeListKey(table, rk, insertSeq), value)
+
+	newLen, err := db.lSetMeta(key, keyInfo.OldHeader, headSeq, tailSeq, ts, wb)
+	if err != nil {
+		db.fixListKey(ts, key)
+		return 0, err
+	}
+	db.topLargeCollKeys.Update(key, int(newLen))
+	err = db.eng.Write(db.defaultWriteOpts, wb)
+	if err == nil {
+		db.publishListChange(CDCOpLInsert, table, key, headSeq, tailSeq, value)
+	}
+	return newLen, err
+}
+
+// LRem scans the list for value and removes up to abs(count) occurrences,
+// front-to-back if count >= 0 or back-to-front if count < 0; count == 0
+// removes every occurrence. It returns the number of elements removed.
+//
+// Matches can land anywhere in the list, so unlike LInsert there is no
+// "shorter side" to shift: we fully repack the surviving elements into a
+// fresh contiguous run starting at headSeq. This costs O(size) rather
+// than O(removed), but it is the only way to keep headSeq/tailSeq exactly
+// spanning the live elements, which fixListKey's compaction pass and LLen
+// both depend on.
+func (db *RockDB) LRem(ts int64, key []byte, count int64, value []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+	keyInfo, headSeq, tailSeq, size, _, err := db.lHeaderAndMeta(ts, key, false)
+	if err != nil {
+		return 0, err
+	}
+	if keyInfo.IsNotExistOrExpired() || size == 0 {
+		return 0, nil
+	}
+	table := keyInfo.Table
+	rk := keyInfo.VerKey
+
+	reverse := count < 0
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+
+	startKey := lEncodeListKey(table, rk, headSeq)
+	stopKey := lEncodeListKey(table, rk, tailSeq)
+	opts := engine.IteratorOpts{
+		Range:   engine.Range{Min: startKey, Max: stopKey, Type: common.RangeClose},
+		Reverse: reverse,
+	}
+	rit, err := engine.NewDBRangeIteratorWithOpts(db.eng, opts)
+	if err != nil {
+		return 0, err
+	}
+	removed := make(map[int64]bool)
+	for ; rit.Valid(); rit.Next() {
+		if limit > 0 && int64(len(removed)) >= limit {
+			break
+		}
+		if !bytes.Equal(rit.Value(), value) {
+			continue
+		}
+		if _, _, seq, derr := lDecodeListKey(rit.RefKey()); derr == nil {
+			removed[seq] = true
+		}
+	}
+	rit.Close()
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	wb := db.wb
+	defer wb.Clear()
+	newSeq := headSeq
+	for seq := headSeq; seq <= tailSeq; seq++ {
+		if removed[seq] {
+			wb.Delete(lEncodeListKey(table, rk, seq))
+			continue
+		}
+		if seq != newSeq {
+			v, verr := db.eng.GetBytesNoLock(db.defaultReadOpts, lEncodeListKey(table, rk, seq))
+			if verr != nil {
+				return 0, verr
+			}
+			wb.Put(lEncodeListKey(table, rk, newSeq), v)
+			wb.Delete(lEncodeListKey(table, rk, seq))
+		}
+		newSeq++
+	}
+	newHead := headSeq
+	newTail := newSeq - 1
+
+	newLen, err := db.lSetMeta(key, keyInfo.OldHeader, newHead, newTail, ts, wb)
+	if err != nil {
+		db.fixListKey(ts, key)
+		return 0, err
+	}
+	if newLen == 0 {
+		db.IncrTableKeyCount(table, -1, wb)
+		db.delExpire(ListType, key, nil, false, wb)
+	}
+	db.topLargeCollKeys.Update(key, int(newLen))
+	removedCnt := int64(len(removed))
+	err = db.eng.Write(db.defaultWriteOpts, wb)
+	if err == nil {
+		db.publishListChange(CDCOpLRem, table, key, newHead, newTail, value)
+	}
+	return removedCnt, err
+}
+
+// LPos returns the 0-based (from head) indexes of element within the list.
+// rank selects which match to start from: a positive rank counts matches
+// from the head, a negative rank counts from the tail (-1 is the last
+// match), and rank == 0 is invalid. count bounds how many indexes are
+// returned (0 means "all remaining matches"); maxlen bounds how many list
+// elements are compared before giving up (0 means "no limit").
+func (db *RockDB) LPos(key []byte, element []byte, rank, count, maxlen int64) ([]int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return nil, err
+	}
+	if rank == 0 {
+		return nil, errListIndex
+	}
+
+	ts := time.Now().UnixNano()
+	keyInfo, headSeq, tailSeq, size, _, err := db.lHeaderAndMeta(ts, key, true)
+	if err != nil {
+		return nil, err
+	}
+	if keyInfo.IsNotExistOrExpired() || size == 0 {
+		return nil, nil
+	}
+	table := keyInfo.Table
+	rk := keyInfo.VerKey
+
+	reverse := rank < 0
+	skip := rank - 1
+	if reverse {
+		skip = -rank - 1
+	}
+
+	startKey := lEncodeListKey(table, rk, headSeq)
+	stopKey := lEncodeListKey(table, rk, tailSeq)
+	opts := engine.IteratorOpts{
+		Range:   engine.Range{Min: startKey, Max: stopKey, Type: common.RangeClose},
+		Reverse: reverse,
+	}
+	rit, err := engine.NewDBRangeIteratorWithOpts(db.eng, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer rit.Close()
+
+	var matched, scanned int64
+	var result []int64
+	for ; rit.Valid(); rit.Next() {
+		if maxlen > 0 && scanned >= maxlen {
+			break
+		}
+		scanned++
+		if !bytes.Equal(rit.Value(), element) {
+			continue
+		}
+		if matched < skip {
+			matched++
+			continue
+		}
+		if _, _, seq, derr := lDecodeListKey(rit.RefKey()); derr == nil {
+			result = append(result, seq-headSeq)
+		}
+		matched++
+		if count > 0 && int64(len(result)) >= count {
+			break
+		}
+	}
+	return result, nil
+}
+
+// LMove atomically pops from srcEnd of src and pushes to dstEnd of dst in a
+// single write batch, mirroring how lpop already couples item delete with
+// lSetMeta in one batch. src and dst may be the same key, in which case the
+// push reuses the meta already updated by the pop.
+func (db *RockDB) LMove(ts int64, src, dst []byte, srcEnd, dstEnd int64) ([]byte, error) {
+	if err := checkKeySize(src); err != nil {
+		return nil, err
+	}
+	if err := checkKeySize(dst); err != nil {
+		return nil, err
+	}
+
+	srcInfo, headSeq, tailSeq, srcSize, _, err := db.lHeaderAndMeta(ts, src, false)
+	if err != nil {
+		return nil, err
+	}
+	if srcInfo.IsNotExistOrExpired() || srcSize == 0 {
+		return nil, nil
+	}
+	srcTable := srcInfo.Table
+	srcRk := srcInfo.VerKey
+
+	popSeq := headSeq
+	if srcEnd == listTailSeq {
+		popSeq = tailSeq
+	}
+	popKey := lEncodeListKey(srcTable, srcRk, popSeq)
+	value, err := db.eng.GetBytesNoLock(db.defaultReadOpts, popKey)
+	if err != nil || value == nil {
+		db.fixListKey(ts, src)
+		return nil, err
+	}
+
+	wb := db.wb
+	defer wb.Clear()
+	wb.Delete(popKey)
+	if srcEnd == listHeadSeq {
+		headSeq++
+	} else {
+		tailSeq--
+	}
+	srcNewLen, err := db.lSetMeta(src, srcInfo.OldHeader, headSeq, tailSeq, ts, wb)
+	if err != nil {
+		db.fixListKey(ts, src)
+		return nil, err
+	}
+	if srcNewLen == 0 {
+		db.IncrTableKeyCount(srcTable, -1, wb)
+		db.delExpire(ListType, src, nil, false, wb)
+	}
+
+	sameKey := bytes.Equal(src, dst)
+	var dstTable, dstRk []byte
+	var dstHead, dstTail, dstSize int64
+	var dstOldHeader *headerMetaValue
+	var dstExpired bool
+	if sameKey {
+		dstTable, dstRk = srcTable, srcRk
+		dstHead, dstTail, dstSize = headSeq, tailSeq, srcNewLen
+		dstOldHeader = srcInfo.OldHeader
+		dstExpired = srcInfo.Expired
+	} else {
+		dstInfo, derr := db.prepareCollKeyForWrite(ts, ListType, dst, nil)
+		if derr != nil {
+			return nil, derr
+		}
+		dstTable, dstRk = dstInfo.Table, dstInfo.VerKey
+		dstHead, dstTail, dstSize, _, err = parseListMeta(dstInfo.MetaData())
+		if err != nil {
+			return nil, err
+		}
+		dstOldHeader = dstInfo.OldHeader
+		dstExpired = dstInfo.Expired
+	}
+
+	pushSeq := dstHead
+	delta := int64(-1)
+	if dstEnd == listTailSeq {
+		pushSeq = dstTail
+		delta = 1
+	}
+	if dstSize > 0 {
+		pushSeq += delta
+	}
+	wb.Put(lEncodeListKey(dstTable, dstRk, pushSeq), value)
+	if dstSize == 0 && !dstExpired {
+		db.IncrTableKeyCount(dstTable, 1, wb)
+	}
+	if dstSize == 0 {
+		// dst had no live elements, so headSeq/tailSeq above came from
+		// either the fresh-key sentinel (parseListMeta's initial
+		// head==tail) or, for a same-key move that just emptied src, a
+		// crossed range (headSeq == tailSeq+1). Either way only one end
+		// is meaningful once size==0, so both ends must be pinned to the
+		// single element just pushed rather than leaving the untouched
+		// one stale/crossed, or lSetMeta's tailSeq-headSeq+1 == 0 check
+		// would delete the meta key out from under the value we just put.
+		dstHead, dstTail = pushSeq, pushSeq
+	} else if dstEnd == listHeadSeq {
+		dstHead = pushSeq
+	} else {
+		dstTail = pushSeq
+	}
+	if _, err := db.lSetMeta(dst, dstOldHeader, dstHead, dstTail, ts, wb); err != nil {
+		db.fixListKey(ts, dst)
+		return nil, err
+	}
+
+	err = db.eng.Write(db.defaultWriteOpts, wb)
+	if err == nil {
+		db.publishListChange(CDCOpLMove, srcTable, src, headSeq, tailSeq, value)
+		if !sameKey {
+			db.publishListChange(CDCOpLMove, dstTable, dst, dstHead, dstTail, value)
+		}
+	}
+	return value, err
+}
+
 func (db *RockDB) LClear(ts int64, key []byte) (int64, error) {
 	if err := checkKeySize(key); err != nil {
 		return 0, err
 	}
+	keyInfo, _ := db.GetCollVersionKey(ts, ListType, key, false)
 	num := db.lDelete(ts, key, db.wb)
 	//delete the expire data related to the list key
 	db.delExpire(ListType, key, nil, false, db.wb)
 	err := db.CommitBatchWrite()
+	if err == nil && num > 0 {
+		db.publishListChange(CDCOpLDel, keyInfo.Table, key, 0, 0, nil)
+	}
 	// num should be the deleted key number
 	if num > 0 {
 		return 1, err
@@ -0,0 +1,249 @@
+package rockredis
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLRemKeepsSeqConsistent exercises lpush/rpush combined with a
+// wildcard LRem (count == 0) to make sure the repacked headSeq/tailSeq
+// range still matches the live element count afterwards, and that the
+// list stays push-able once it has been repacked.
+func TestLRemKeepsSeqConsistent(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+
+	ts := time.Now().UnixNano()
+	key := []byte("test:lrem_seq_key")
+	defer db.LClear(ts, key)
+
+	for i := 0; i < 5; i++ {
+		if _, err := db.LPush(ts, key, []byte("dup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := db.RPush(ts, key, []byte("dup")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.RPush(ts, key, []byte("keep-1"), []byte("keep-2")); err != nil {
+		t.Fatal(err)
+	}
+
+	llenBefore, err := db.LLen(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if llenBefore != 12 {
+		t.Fatalf("expected 12 elements before LRem, got %v", llenBefore)
+	}
+
+	removed, err := db.LRem(ts, key, 0, []byte("dup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 10 {
+		t.Fatalf("expected to remove 10 occurrences of dup, removed %v", removed)
+	}
+
+	llenAfter, err := db.LLen(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if llenAfter != 2 {
+		t.Fatalf("expected 2 elements remaining after LRem, got %v", llenAfter)
+	}
+
+	vals, err := db.LRange(key, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 2 || string(vals[0]) != "keep-1" || string(vals[1]) != "keep-2" {
+		t.Fatalf("unexpected surviving elements after LRem: %v", vals)
+	}
+
+	// Pushing again afterward must still succeed and grow the list by
+	// exactly one, proving LRem's repack left headSeq/tailSeq as a valid,
+	// contiguous, extendable range rather than a stale or gapped one.
+	if _, err := db.RPush(ts, key, []byte("keep-3")); err != nil {
+		t.Fatal(err)
+	}
+	llenFinal, err := db.LLen(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if llenFinal != 3 {
+		t.Fatalf("expected 3 elements after further rpush, got %v", llenFinal)
+	}
+}
+
+// TestLMoveSameKeySingleElement moves the sole element of a 1-element list
+// to the opposite end of the same key (the LMOVE k k LEFT RIGHT /
+// RPOPLPUSH-equivalent shape) and makes sure the list is still visible
+// afterward: popping dst's old headSeq/tailSeq range out from under an
+// about-to-be-emptied list must not leave the re-pushed element's meta
+// crossed (tailSeq < headSeq), which would make lSetMeta delete the meta
+// key even though the value itself is still in the engine.
+func TestLMoveSameKeySingleElement(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+
+	ts := time.Now().UnixNano()
+	key := []byte("test:lmove_samekey_key")
+	defer db.LClear(ts, key)
+
+	if _, err := db.RPush(ts, key, []byte("only")); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := db.LMove(ts, key, key, ListHeadSeq, ListTailSeq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(moved) != "only" {
+		t.Fatalf("expected moved value %q, got %q", "only", moved)
+	}
+	llen, err := db.LLen(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if llen != 1 {
+		t.Fatalf("expected list to still contain 1 element after same-key LMove LEFT->RIGHT, got %v", llen)
+	}
+	vals, err := db.LRange(key, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || string(vals[0]) != "only" {
+		t.Fatalf("unexpected contents after same-key LMove LEFT->RIGHT: %v", vals)
+	}
+
+	moved, err = db.LMove(ts, key, key, ListTailSeq, ListHeadSeq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(moved) != "only" {
+		t.Fatalf("expected moved value %q, got %q", "only", moved)
+	}
+	llen, err = db.LLen(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if llen != 1 {
+		t.Fatalf("expected list to still contain 1 element after same-key LMove RIGHT->LEFT, got %v", llen)
+	}
+
+	// The list must still be extendable afterward, proving headSeq/tailSeq
+	// were left as a valid, non-crossed range rather than corrupted.
+	if _, err := db.RPush(ts, key, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	llen, err = db.LLen(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if llen != 2 {
+		t.Fatalf("expected 2 elements after further rpush, got %v", llen)
+	}
+}
+
+// TestLInsertBeforeAfter exercises LINSERT on both sides of a pivot deep
+// enough in the list that it must shift elements, and checks the
+// resulting order as well as the "pivot not found" case.
+func TestLInsertBeforeAfter(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+
+	ts := time.Now().UnixNano()
+	key := []byte("test:linsert_key")
+	defer db.LClear(ts, key)
+
+	if _, err := db.RPush(ts, key, []byte("a"), []byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.LInsert(ts, key, ListInsertBefore, []byte("b"), []byte("before-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected length 4 after LInsert before, got %v", n)
+	}
+	n, err = db.LInsert(ts, key, ListInsertAfter, []byte("b"), []byte("after-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("expected length 5 after LInsert after, got %v", n)
+	}
+
+	vals, err := db.LRange(key, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "before-b", "b", "after-b", "c"}
+	if len(vals) != len(want) {
+		t.Fatalf("unexpected length after LInsert: %v", vals)
+	}
+	for i, w := range want {
+		if string(vals[i]) != w {
+			t.Fatalf("unexpected element at index %v: got %q, want %q (full: %v)", i, vals[i], w, vals)
+		}
+	}
+
+	n, err = db.LInsert(ts, key, ListInsertBefore, []byte("missing"), []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != -1 {
+		t.Fatalf("expected -1 for a pivot that doesn't exist, got %v", n)
+	}
+}
+
+// TestLPosBasic checks LPos finds the first (default rank) and last (rank
+// -1) occurrence of a repeated element, and reports no matches for a value
+// that isn't present.
+func TestLPosBasic(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+
+	ts := time.Now().UnixNano()
+	key := []byte("test:lpos_key")
+	defer db.LClear(ts, key)
+
+	if _, err := db.RPush(ts, key, []byte("a"), []byte("b"), []byte("a"), []byte("c"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	pos, err := db.LPos(key, []byte("a"), 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pos) != 1 || pos[0] != 0 {
+		t.Fatalf("expected first occurrence of \"a\" at index 0, got %v", pos)
+	}
+
+	pos, err = db.LPos(key, []byte("a"), -1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pos) != 1 || pos[0] != 4 {
+		t.Fatalf("expected last occurrence of \"a\" at index 4, got %v", pos)
+	}
+
+	pos, err = db.LPos(key, []byte("a"), 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pos) != 3 || pos[0] != 0 || pos[1] != 2 || pos[2] != 4 {
+		t.Fatalf("expected all occurrences of \"a\" at [0 2 4], got %v", pos)
+	}
+
+	pos, err = db.LPos(key, []byte("missing"), 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pos) != 0 {
+		t.Fatalf("expected no matches for a missing value, got %v", pos)
+	}
+}
@@ -0,0 +1,585 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/youzan/ZanRedisDB/metric"
+)
+
+// errJournalCorrupt is returned by decodeJournalFrame when a frame's crc32
+// doesn't match its payload, indicating a torn write left behind by a crash
+// mid-append.
+var errJournalCorrupt = errors.New("journal: corrupt frame")
+
+// journalFrameHeaderSize is the framing overhead Append prepends to every
+// entry: a 4-byte big-endian payload length, then a 4-byte big-endian
+// crc32(payload).
+const journalFrameHeaderSize = 8
+
+const journalStateVersion = 1
+
+const journalSegmentPrefix = "journal."
+const journalSegmentSuffix = ".dat"
+const journalStateFile = "journal.state.json"
+
+// Fsync cadences a JournalConfig can be given, mirroring the always /
+// interval:Nms / never knobs operators already use for other durability
+// trade-offs in this codebase.
+const (
+	fsyncAlways = iota
+	fsyncInterval
+	fsyncNever
+)
+
+// FsyncPolicy controls how aggressively WriteJournal.Append calls
+// File.Sync on the active segment.
+type FsyncPolicy struct {
+	mode     int
+	interval time.Duration
+}
+
+// ParseFsyncPolicy parses "always", "never", or "interval:Nms" (e.g.
+// "interval:20ms") into a FsyncPolicy.
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch {
+	case s == "always":
+		return FsyncPolicy{mode: fsyncAlways}, nil
+	case s == "never":
+		return FsyncPolicy{mode: fsyncNever}, nil
+	case strings.HasPrefix(s, "interval:"):
+		d, err := time.ParseDuration(strings.TrimPrefix(s, "interval:"))
+		if err != nil {
+			return FsyncPolicy{}, fmt.Errorf("journal: invalid fsync interval %q: %w", s, err)
+		}
+		return FsyncPolicy{mode: fsyncInterval, interval: d}, nil
+	default:
+		return FsyncPolicy{}, fmt.Errorf("journal: unrecognized fsync policy %q", s)
+	}
+}
+
+// JournalConfig holds the on-disk knobs for a WriteJournal.
+type JournalConfig struct {
+	// Dir is the directory segments and the cursor state file live in.
+	Dir string
+	// MaxSegmentSize bounds how large a single segment file grows before
+	// Append rotates to a new one.
+	MaxSegmentSize int64
+	// MaxUnsyncedEntries forces a sync once this many entries have been
+	// appended without one, regardless of Fsync's cadence.
+	MaxUnsyncedEntries int
+	// Fsync is "always", "never", or "interval:Nms".
+	Fsync string
+}
+
+// journalCheckpoint is the (segment, offset) the read cursor should resume
+// from once the entry at a given LSN is known to be safely applied.
+type journalCheckpoint struct {
+	seg int64
+	off int64
+}
+
+// journalState is the versioned blob WriteJournal persists to
+// journalStateFile so NewWriteJournal can resume the write and read
+// cursors across a restart without replaying the whole journal.
+type journalState struct {
+	Version   int   `json:"version"`
+	NextLSN   int64 `json:"next_lsn"`
+	CursorLSN int64 `json:"cursor_lsn"`
+	CursorSeg int64 `json:"cursor_seg"`
+	CursorOff int64 `json:"cursor_off"`
+}
+
+// WriteJournal is a bounded, segmented file-backed queue that sits between
+// pipelineCommand and the partition dispatcher: a fused pipeline batch is
+// durably appended before the client is acked, and a background drainer
+// applies each entry and only retires it once the caller confirms (via Ack)
+// that the Raft apply index has passed that entry's LSN. This closes the
+// crash window between "client acked" and "Raft committed" that a coalesced
+// PLSET/PLDEL/... batch would otherwise be able to fall into.
+type WriteJournal struct {
+	cfg   JournalConfig
+	fsync FsyncPolicy
+	apply func(lsn int64, payload []byte) error
+
+	mu           sync.Mutex
+	writeFile    *os.File
+	writeSeg     int64
+	writeOffset  int64
+	unsynced     int
+	lastSyncTime time.Time
+	nextLSN      int64
+
+	ackMu       sync.Mutex
+	acked       map[int64]bool
+	checkpoints map[int64]journalCheckpoint
+	cursorLSN   int64
+	cursorSeg   int64
+	cursorOff   int64
+	lastSaved   time.Time
+
+	depth atomic.Int64
+
+	stopC chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewWriteJournal opens (or creates) a WriteJournal rooted at cfg.Dir,
+// resuming from its persisted state if one exists, or replaying every
+// segment found on disk to rebuild it otherwise. apply is called by the
+// background drainer (once Start is called) for every entry in LSN order;
+// the caller must eventually call Ack(lsn) once that entry's effect is
+// durably committed through Raft.
+func NewWriteJournal(cfg JournalConfig, apply func(lsn int64, payload []byte) error) (*WriteJournal, error) {
+	fsync, err := ParseFsyncPolicy(cfg.Fsync)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	j := &WriteJournal{
+		cfg:         cfg,
+		fsync:       fsync,
+		apply:       apply,
+		acked:       make(map[int64]bool),
+		checkpoints: make(map[int64]journalCheckpoint),
+	}
+	if err := j.recover(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *WriteJournal) segmentPath(seg int64) string {
+	return filepath.Join(j.cfg.Dir, fmt.Sprintf("%s%06d%s", journalSegmentPrefix, seg, journalSegmentSuffix))
+}
+
+func (j *WriteJournal) listSegments() ([]int64, error) {
+	entries, err := os.ReadDir(j.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []int64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, journalSegmentPrefix) || !strings.HasSuffix(name, journalSegmentSuffix) {
+			continue
+		}
+		numStr := strings.TrimSuffix(strings.TrimPrefix(name, journalSegmentPrefix), journalSegmentSuffix)
+		n, err := strconv.ParseInt(numStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, n)
+	}
+	sort.Slice(segs, func(i, k int) bool { return segs[i] < segs[k] })
+	return segs, nil
+}
+
+// recover loads journalStateFile if present, or else replays every segment
+// on disk from the start to rebuild nextLSN; either way it leaves the
+// WriteJournal ready for Append to resume onto the newest segment.
+func (j *WriteJournal) recover() error {
+	segs, err := j.listSegments()
+	if err != nil {
+		return err
+	}
+	if st, err := j.loadState(); err == nil {
+		j.nextLSN = st.NextLSN
+		j.cursorLSN = st.CursorLSN
+		j.cursorSeg = st.CursorSeg
+		j.cursorOff = st.CursorOff
+		j.depth.Store(st.NextLSN - st.CursorLSN)
+	} else {
+		// No usable state file: replay every segment to recompute nextLSN,
+		// and resume the read cursor from the very start so nothing queued
+		// is silently skipped.
+		var lsn int64
+		for _, seg := range segs {
+			n, err := countSegmentFrames(j.segmentPath(seg))
+			if err != nil {
+				return err
+			}
+			lsn += n
+		}
+		j.nextLSN = lsn
+		j.depth.Store(lsn)
+	}
+	if len(segs) > 0 {
+		j.writeSeg = segs[len(segs)-1]
+	}
+	fi, err := os.Stat(j.segmentPath(j.writeSeg))
+	if err == nil {
+		j.writeOffset = fi.Size()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func countSegmentFrames(path string) (int64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var n int64
+	for {
+		if _, err := decodeJournalFrame(f); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == errJournalCorrupt {
+				break
+			}
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func encodeJournalFrame(payload []byte) []byte {
+	frame := make([]byte, journalFrameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[journalFrameHeaderSize:], payload)
+	return frame
+}
+
+func decodeJournalFrame(r io.Reader) ([]byte, error) {
+	payload, _, err := decodeJournalFrameAt(r)
+	return payload, err
+}
+
+// decodeJournalFrameAt is decodeJournalFrame plus frameLen, the number of
+// bytes r actually advanced by (header plus however much of the payload was
+// read, even on a short read or a crc mismatch), so a caller that hit
+// errJournalCorrupt knows exactly how far to skip to get past the bad
+// frame instead of re-reading it forever.
+func decodeJournalFrameAt(r io.Reader) (payload []byte, frameLen int64, err error) {
+	var hdr [journalFrameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, 0, err
+	}
+	n := binary.BigEndian.Uint32(hdr[0:4])
+	wantCRC := binary.BigEndian.Uint32(hdr[4:8])
+	payload = make([]byte, n)
+	read, err := io.ReadFull(r, payload)
+	frameLen = int64(journalFrameHeaderSize + read)
+	if err != nil {
+		return nil, frameLen, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(payload) != wantCRC {
+		return nil, frameLen, errJournalCorrupt
+	}
+	return payload, frameLen, nil
+}
+
+// Append frames payload (the fused batch's RESP bytes) and writes it to the
+// active segment, returning the LSN assigned to it. The segment is synced
+// before Append returns only when the Fsync policy or MaxUnsyncedEntries
+// calls for it on this append; under "always" the caller may ack the client
+// as soon as Append returns nil.
+func (j *WriteJournal) Append(payload []byte) (int64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	frame := encodeJournalFrame(payload)
+	if j.writeFile == nil || j.writeOffset+int64(len(frame)) > j.cfg.MaxSegmentSize {
+		if err := j.rotateWriteSegmentLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := j.writeFile.Write(frame)
+	if err != nil {
+		return 0, err
+	}
+	j.writeOffset += int64(n)
+	j.nextLSN++
+	lsn := j.nextLSN
+	j.unsynced++
+
+	if j.shouldSyncLocked() {
+		if err := j.writeFile.Sync(); err != nil {
+			return 0, err
+		}
+		j.unsynced = 0
+		j.lastSyncTime = time.Now()
+	}
+
+	j.depth.Add(1)
+	metric.JournalQueueDepth.Set(float64(j.depth.Load()))
+	return lsn, nil
+}
+
+func (j *WriteJournal) shouldSyncLocked() bool {
+	switch j.fsync.mode {
+	case fsyncAlways:
+		return true
+	case fsyncNever:
+		return j.cfg.MaxUnsyncedEntries > 0 && j.unsynced >= j.cfg.MaxUnsyncedEntries
+	case fsyncInterval:
+		if j.cfg.MaxUnsyncedEntries > 0 && j.unsynced >= j.cfg.MaxUnsyncedEntries {
+			return true
+		}
+		return time.Since(j.lastSyncTime) >= j.fsync.interval
+	default:
+		return true
+	}
+}
+
+func (j *WriteJournal) rotateWriteSegmentLocked() error {
+	if j.writeFile != nil {
+		j.writeFile.Sync()
+		j.writeFile.Close()
+		j.writeSeg++
+	}
+	f, err := os.OpenFile(j.segmentPath(j.writeSeg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.writeFile = f
+	j.writeOffset = 0
+	j.unsynced = 0
+	return nil
+}
+
+func (j *WriteJournal) currentWriteSeg() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.writeSeg
+}
+
+// Start launches the background drainer, which pops entries from the read
+// cursor in LSN order and hands each to apply.
+func (j *WriteJournal) Start() {
+	j.stopC = make(chan struct{})
+	j.wg.Add(1)
+	go j.drain()
+}
+
+// Stop halts the drainer and flushes the active segment plus the cursor
+// state, so a restart resumes without replaying everything already acked.
+func (j *WriteJournal) Stop() {
+	if j.stopC != nil {
+		close(j.stopC)
+		j.wg.Wait()
+		j.stopC = nil
+	}
+	j.mu.Lock()
+	if j.writeFile != nil {
+		j.writeFile.Sync()
+	}
+	j.mu.Unlock()
+	j.saveState()
+}
+
+func (j *WriteJournal) drain() {
+	defer j.wg.Done()
+
+	j.ackMu.Lock()
+	seg, off, lsn := j.cursorSeg, j.cursorOff, j.cursorLSN
+	j.ackMu.Unlock()
+
+	var rf *os.File
+	defer func() {
+		if rf != nil {
+			rf.Close()
+		}
+	}()
+	backoff := time.Millisecond * 10
+	for {
+		select {
+		case <-j.stopC:
+			return
+		default:
+		}
+
+		if rf == nil {
+			f, err := os.Open(j.segmentPath(seg))
+			if err != nil {
+				time.Sleep(backoff)
+				continue
+			}
+			if off > 0 {
+				if _, err := f.Seek(off, io.SeekStart); err != nil {
+					f.Close()
+					time.Sleep(backoff)
+					continue
+				}
+			}
+			rf = f
+		}
+
+		beforeFrame := off
+		payload, frameLen, err := decodeJournalFrameAt(rf)
+		if err != nil {
+			rf.Close()
+			rf = nil
+			if err == errJournalCorrupt {
+				// The frame at off is torn or bit-flipped and can never be
+				// read cleanly, live segment or not: retrying the same
+				// offset forever would stall the drainer on this one entry
+				// permanently. Skip past it (frameLen covers however much
+				// of the header/payload we did manage to read) and keep
+				// going instead, so one bad frame costs one lost entry
+				// rather than the rest of the journal.
+				off = beforeFrame + frameLen
+				metric.JournalCorruptFrames.Inc()
+				continue
+			}
+			if seg < j.currentWriteSeg() {
+				// This segment is sealed (a later one exists) and we've
+				// hit its end: move on to the next one.
+				seg++
+				off = 0
+				continue
+			}
+			// Still the live segment with nothing new appended yet.
+			time.Sleep(backoff)
+			continue
+		}
+		newOff, _ := rf.Seek(0, io.SeekCurrent)
+		lsn++
+
+		if err := j.apply(lsn, payload); err != nil {
+			lsn--
+			rf.Seek(beforeFrame, io.SeekStart)
+			time.Sleep(backoff)
+			continue
+		}
+		off = newOff
+		j.trackCheckpoint(lsn, seg, off)
+		metric.JournalDrainerLag.Set(float64(j.nextLSNSnapshot() - lsn))
+	}
+}
+
+func (j *WriteJournal) nextLSNSnapshot() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.nextLSN
+}
+
+func (j *WriteJournal) trackCheckpoint(lsn, seg, off int64) {
+	j.ackMu.Lock()
+	j.checkpoints[lsn] = journalCheckpoint{seg: seg, off: off}
+	j.ackMu.Unlock()
+}
+
+// Ack records that lsn has been durably applied (its Raft apply index has
+// passed it), advancing the persisted read cursor past the longest
+// contiguous run of acked entries and reclaiming any segment files that
+// now fall entirely behind it.
+func (j *WriteJournal) Ack(lsn int64) {
+	j.ackMu.Lock()
+	j.acked[lsn] = true
+	for {
+		next := j.cursorLSN + 1
+		cp, ok := j.checkpoints[next]
+		if !ok || !j.acked[next] {
+			break
+		}
+		delete(j.checkpoints, next)
+		delete(j.acked, next)
+		j.cursorLSN = next
+		j.cursorSeg = cp.seg
+		j.cursorOff = cp.off
+		j.depth.Add(-1)
+	}
+	shouldSave := time.Since(j.lastSaved) >= time.Second
+	if shouldSave {
+		j.lastSaved = time.Now()
+	}
+	j.ackMu.Unlock()
+
+	metric.JournalQueueDepth.Set(float64(j.depth.Load()))
+	j.reclaimSegments()
+	if shouldSave {
+		j.saveState()
+	}
+}
+
+// reclaimSegments removes segment files that fall entirely behind the
+// persisted read cursor; they can never be read again.
+func (j *WriteJournal) reclaimSegments() {
+	j.ackMu.Lock()
+	cursorSeg := j.cursorSeg
+	j.ackMu.Unlock()
+
+	segs, err := j.listSegments()
+	if err != nil {
+		return
+	}
+	for _, seg := range segs {
+		if seg >= cursorSeg {
+			continue
+		}
+		os.Remove(j.segmentPath(seg))
+	}
+}
+
+func (j *WriteJournal) saveState() error {
+	j.mu.Lock()
+	nextLSN := j.nextLSN
+	j.mu.Unlock()
+
+	j.ackMu.Lock()
+	st := journalState{
+		Version:   journalStateVersion,
+		NextLSN:   nextLSN,
+		CursorLSN: j.cursorLSN,
+		CursorSeg: j.cursorSeg,
+		CursorOff: j.cursorOff,
+	}
+	j.ackMu.Unlock()
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(j.cfg.Dir, journalStateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (j *WriteJournal) loadState() (journalState, error) {
+	path := filepath.Join(j.cfg.Dir, journalStateFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return journalState{}, err
+	}
+	var st journalState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return journalState{}, err
+	}
+	if st.Version != journalStateVersion {
+		return journalState{}, fmt.Errorf("journal: unsupported state version %v", st.Version)
+	}
+	return st, nil
+}
+
+// Depth returns the number of appended entries the read cursor has not yet
+// moved past, the same value exposed as the JournalQueueDepth gauge. An
+// entry leaves this count only once the drainer has applied it and Ack has
+// been called for it; an acked entry the drainer has not applied still
+// counts.
+func (j *WriteJournal) Depth() int64 {
+	return j.depth.Load()
+}
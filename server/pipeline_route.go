@@ -0,0 +1,278 @@
+package server
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/absolute8511/redcon"
+)
+
+// errNoPartitions is returned by hashRing.Get when no partition has been
+// added yet.
+var errNoPartitions = errors.New("pipeline: no partitions registered in hash ring")
+
+// hashRing is a sorted ring of uint32 hashes with a configurable number of
+// virtual nodes per partition, modeled on the consistent-hash helper
+// go-redis ships in internal/consistenthash: binary-search lookup on the
+// ring means a partition added or removed only reshuffles ~1/N of keys,
+// instead of the full keyspace a naive mod-N hash would.
+type hashRing struct {
+	mu       sync.RWMutex
+	replicas int
+	hashFn   func([]byte) uint32
+	ring     []uint32
+	nodes    map[uint32]string
+}
+
+// newHashRing returns a ring with replicas virtual nodes per partition
+// added via Add. A zero or negative replicas defaults to 160, the same
+// default go-redis' consistenthash.New uses.
+func newHashRing(replicas int) *hashRing {
+	if replicas <= 0 {
+		replicas = 160
+	}
+	return &hashRing{
+		replicas: replicas,
+		hashFn:   crc32.ChecksumIEEE,
+		nodes:    make(map[uint32]string),
+	}
+}
+
+// Add inserts partitions into the ring, each getting r.replicas virtual
+// nodes spread across the keyspace.
+func (r *hashRing) Add(partitions ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range partitions {
+		for i := 0; i < r.replicas; i++ {
+			h := r.hashFn([]byte(strconv.Itoa(i) + p))
+			r.ring = append(r.ring, h)
+			r.nodes[h] = p
+		}
+	}
+	sort.Slice(r.ring, func(i, k int) bool { return r.ring[i] < r.ring[k] })
+}
+
+// Remove deletes partition, and all of its virtual nodes, from the ring.
+func (r *hashRing) Remove(partition string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.ring[:0]
+	for _, h := range r.ring {
+		if r.nodes[h] == partition {
+			delete(r.nodes, h)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	r.ring = kept
+}
+
+// Get returns the partition key belongs to: the partition owning the
+// first ring entry at or after hash(key), wrapping around to the
+// smallest entry if key's hash falls past every one of them.
+func (r *hashRing) Get(key []byte) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.ring) == 0 {
+		return "", errNoPartitions
+	}
+	h := r.hashFn(key)
+	idx := sort.Search(len(r.ring), func(i int) bool { return r.ring[i] >= h })
+	if idx == len(r.ring) {
+		idx = 0
+	}
+	return r.nodes[r.ring[idx]], nil
+}
+
+// PartitionBatch is one partition's share of a coalesced pipeline run:
+// Cmd is the merged PL* command covering just that partition's keys, and
+// Indices records, in submission order, which 0-based position within
+// the original run each of Cmd's folded sub-commands came from, so
+// replies can be scattered back into submission order afterward.
+type PartitionBatch struct {
+	Partition string
+	Cmd       redcon.Command
+	Indices   []int
+}
+
+// SplitPipelineByPartition regroups a run of same-shaped single-key
+// commands — the same run pipelineCommand would otherwise fold wholesale
+// into one PL* command — into one PL* command per partition, using ring
+// to look up each command's partition from its key argument. A run
+// confined to a single partition still yields exactly one PartitionBatch,
+// so this is a drop-in replacement for folding the whole run blind to
+// partition boundaries.
+func SplitPipelineByPartition(ring *hashRing, spec plCoalesceSpec, cmds []redcon.Command) ([]PartitionBatch, error) {
+	byPartition := make(map[string]*PartitionBatch)
+	var order []string
+	for i, cmd := range cmds {
+		// Partition membership is keyed off the command's primary key
+		// argument, the same as a non-coalesced command would be routed.
+		partition, err := ring.Get(cmd.Args[spec.keyArgs[0]])
+		if err != nil {
+			return nil, err
+		}
+		pb, ok := byPartition[partition]
+		if !ok {
+			pb = &PartitionBatch{Partition: partition}
+			byPartition[partition] = pb
+			order = append(order, partition)
+		}
+		pb.Indices = append(pb.Indices, i)
+	}
+
+	batches := make([]PartitionBatch, 0, len(order))
+	for _, partition := range order {
+		pb := byPartition[partition]
+		args := make([][]byte, 0, 1+len(spec.keyArgs)*len(pb.Indices))
+		args = append(args, []byte(spec.plCmd))
+		for _, idx := range pb.Indices {
+			sub := cmds[idx]
+			for _, argIdx := range spec.keyArgs {
+				args = append(args, sub.Args[argIdx])
+			}
+		}
+		pb.Cmd = buildCommand(args)
+		batches = append(batches, *pb)
+	}
+	return batches, nil
+}
+
+// maxConcurrentPartitionBatches bounds how many of a split pipeline's
+// per-partition sub-batches are dispatched at once, so one oversized
+// pipeline can't spin up an unbounded number of goroutines.
+const maxConcurrentPartitionBatches = 16
+
+// DispatchPartitionBatches runs exec concurrently for every batch, bounded
+// by a small worker pool, and scatters each batch's per-entry replies
+// back into a single slice of length total ordered exactly as the
+// original, un-split run was submitted. If any exec call fails, its error
+// is returned alongside whatever replies the other batches did produce.
+func DispatchPartitionBatches(batches []PartitionBatch, total int, exec func(b PartitionBatch) ([]interface{}, error)) ([]interface{}, error) {
+	results := make([]interface{}, total)
+	sem := make(chan struct{}, maxConcurrentPartitionBatches)
+	errs := make([]error, len(batches))
+
+	var wg sync.WaitGroup
+	wg.Add(len(batches))
+	for bi, b := range batches {
+		sem <- struct{}{}
+		go func(bi int, b PartitionBatch) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			recordPartitionBatch(b.Partition, len(b.Indices))
+			replies, err := exec(b)
+			if err != nil {
+				errs[bi] = err
+				return
+			}
+			for k, idx := range b.Indices {
+				if k < len(replies) {
+					results[idx] = replies[k]
+				}
+			}
+		}(bi, b)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// pipelineStatsState backs the PIPELINE.STATS admin command: running
+// totals for the coalesce ratio and average fan-out width, plus a bounded
+// window of recent per-partition batch sizes.
+var pipelineStatsState = struct {
+	mu               sync.Mutex
+	totalCommands    int64
+	totalCoalesced   int64
+	totalRuns        int64
+	totalFanoutWidth int64
+	partitionSizes   map[string][]int
+}{
+	partitionSizes: make(map[string][]int),
+}
+
+// maxTrackedPartitionSamples bounds how many recent batch sizes
+// PIPELINE.STATS keeps per partition, so a long-lived server doesn't grow
+// this state unboundedly.
+const maxTrackedPartitionSamples = 100
+
+// recordPipelineRun records one pipelineCommand run's length, feeding
+// PIPELINE.STATS' coalesce ratio (coalesced commands / total commands
+// seen) and average fan-out width (mean length of runs that did fold).
+func recordPipelineRun(runLen int) {
+	pipelineStatsState.mu.Lock()
+	defer pipelineStatsState.mu.Unlock()
+	pipelineStatsState.totalCommands += int64(runLen)
+	if runLen > 1 {
+		pipelineStatsState.totalCoalesced += int64(runLen)
+		pipelineStatsState.totalRuns++
+		pipelineStatsState.totalFanoutWidth += int64(runLen)
+	}
+}
+
+// recordPartitionBatch records one partition's batch size from a split
+// dispatch, feeding PIPELINE.STATS' per-partition batch size report.
+func recordPartitionBatch(partition string, size int) {
+	pipelineStatsState.mu.Lock()
+	defer pipelineStatsState.mu.Unlock()
+	samples := append(pipelineStatsState.partitionSizes[partition], size)
+	if len(samples) > maxTrackedPartitionSamples {
+		samples = samples[len(samples)-maxTrackedPartitionSamples:]
+	}
+	pipelineStatsState.partitionSizes[partition] = samples
+}
+
+// HandlePipelineStats implements the PIPELINE.STATS admin command,
+// reporting the pipeline coalescer's coalesce ratio, average fan-out
+// width, and recent average per-partition batch sizes, so operators can
+// tune the hash ring's virtual-node replica count.
+func HandlePipelineStats(conn redcon.Conn, cmd redcon.Command) error {
+	pipelineStatsState.mu.Lock()
+	totalCommands := pipelineStatsState.totalCommands
+	totalCoalesced := pipelineStatsState.totalCoalesced
+	totalRuns := pipelineStatsState.totalRuns
+	totalFanoutWidth := pipelineStatsState.totalFanoutWidth
+	partitionSizes := make(map[string][]int, len(pipelineStatsState.partitionSizes))
+	for k, v := range pipelineStatsState.partitionSizes {
+		cp := make([]int, len(v))
+		copy(cp, v)
+		partitionSizes[k] = cp
+	}
+	pipelineStatsState.mu.Unlock()
+
+	coalesceRatio := 0.0
+	if totalCommands > 0 {
+		coalesceRatio = float64(totalCoalesced) / float64(totalCommands)
+	}
+	avgFanout := 0.0
+	if totalRuns > 0 {
+		avgFanout = float64(totalFanoutWidth) / float64(totalRuns)
+	}
+
+	pairs := [][2]interface{}{
+		{"coalesce_ratio", coalesceRatio},
+		{"avg_fanout_width", avgFanout},
+	}
+	for partition, sizes := range partitionSizes {
+		var sum int
+		for _, s := range sizes {
+			sum += s
+		}
+		avg := 0.0
+		if len(sizes) > 0 {
+			avg = float64(sum) / float64(len(sizes))
+		}
+		pairs = append(pairs, [2]interface{}{"partition:" + partition, avg})
+	}
+	return NewWriter(conn).WriteMap(pairs)
+}
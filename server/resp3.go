@@ -0,0 +1,348 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/absolute8511/redcon"
+)
+
+const (
+	respProto2 = 2
+	respProto3 = 3
+)
+
+var errUnsupportedProtoVer = errors.New("NOPROTO unsupported protocol version")
+
+// connState is per-connection state attached via redcon.Conn's
+// Context/SetContext, most importantly the RESP protocol version
+// negotiated by HELLO. A connection that never sends HELLO stays on the
+// RESP2 default.
+type connState struct {
+	protoVer int
+}
+
+func connProtoVer(conn redcon.Conn) int {
+	if conn == nil {
+		return respProto2
+	}
+	if cs, ok := conn.Context().(*connState); ok && cs != nil {
+		return cs.protoVer
+	}
+	return respProto2
+}
+
+func setConnProtoVer(conn redcon.Conn, ver int) {
+	if cs, ok := conn.Context().(*connState); ok && cs != nil {
+		cs.protoVer = ver
+		return
+	}
+	conn.SetContext(&connState{protoVer: ver})
+}
+
+// HandleHello implements HELLO [protover [AUTH user pass]] [SETNAME
+// name], negotiating the RESP protocol version for conn and replying
+// with the usual server/version/proto/id/mode/role/modules info (a true
+// RESP3 map when the negotiated version is 3, a flat RESP2 array
+// otherwise). AUTH/SETNAME are accepted syntactically but credential
+// checks belong to the auth layer, not this negotiation path.
+func HandleHello(conn redcon.Conn, cmd redcon.Command) error {
+	ver := connProtoVer(conn)
+	i := 1
+	if i < len(cmd.Args) {
+		n, err := strconv.ParseInt(string(cmd.Args[i]), 10, 64)
+		if err != nil || (n != respProto2 && n != respProto3) {
+			return errUnsupportedProtoVer
+		}
+		ver = int(n)
+		i++
+	}
+	for i < len(cmd.Args) {
+		switch qcmdlower(cmd.Args[i]) {
+		case "auth":
+			if i+2 >= len(cmd.Args) {
+				return ErrWrongNumberOfArguments
+			}
+			i += 3
+		case "setname":
+			if i+1 >= len(cmd.Args) {
+				return ErrWrongNumberOfArguments
+			}
+			i += 2
+		default:
+			return ErrUnknownCommand
+		}
+	}
+	setConnProtoVer(conn, ver)
+
+	w := NewWriter(conn)
+	return w.WriteMap([][2]interface{}{
+		{"server", "redis"},
+		{"version", "6.2.0"},
+		{"proto", int64(ver)},
+		{"id", int64(0)},
+		{"mode", "standalone"},
+		{"role", "master"},
+		{"modules", []interface{}{}},
+	})
+}
+
+// Writer wraps a redcon.Conn and picks RESP2 or RESP3 wire encoding for
+// composite reply types based on the connection's negotiated protocol
+// version: RESP3 clients get true maps (%), sets (~), doubles (,),
+// booleans (#), big numbers ((), verbatim strings (=) and null (_);
+// RESP2 clients transparently get the classic bulk-string/array/integer
+// downgrade of each, exactly as Redis itself does.
+type Writer struct {
+	conn     redcon.Conn
+	protoVer int
+}
+
+// NewWriter returns a Writer for conn, picking up whatever protocol
+// version HELLO (if any) negotiated for it.
+func NewWriter(conn redcon.Conn) *Writer {
+	return &Writer{conn: conn, protoVer: connProtoVer(conn)}
+}
+
+func (w *Writer) isResp3() bool {
+	return w.protoVer >= respProto3
+}
+
+// WriteMap writes pairs as a RESP3 map under RESP3, or as the classic
+// flat alternating key/value array HGETALL has always used under RESP2.
+func (w *Writer) WriteMap(pairs [][2]interface{}) error {
+	if w.isResp3() {
+		buf := []byte("%" + strconv.Itoa(len(pairs)) + "\r\n")
+		for _, kv := range pairs {
+			for _, v := range kv {
+				b, err := encodeRESP3(v)
+				if err != nil {
+					return err
+				}
+				buf = append(buf, b...)
+			}
+		}
+		w.conn.WriteRaw(buf)
+		return nil
+	}
+	w.conn.WriteArray(len(pairs) * 2)
+	for _, kv := range pairs {
+		for _, v := range kv {
+			if err := w.writeResp2Scalar(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteSet writes items as a RESP3 set under RESP3, or as a plain array
+// under RESP2.
+func (w *Writer) WriteSet(items []interface{}) error {
+	if w.isResp3() {
+		buf := []byte("~" + strconv.Itoa(len(items)) + "\r\n")
+		for _, v := range items {
+			b, err := encodeRESP3(v)
+			if err != nil {
+				return err
+			}
+			buf = append(buf, b...)
+		}
+		w.conn.WriteRaw(buf)
+		return nil
+	}
+	w.conn.WriteArray(len(items))
+	for _, v := range items {
+		if err := w.writeResp2Scalar(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDouble writes f as a RESP3 double under RESP3, or as a bulk
+// string formatted the way Redis has always formatted float replies
+// under RESP2.
+func (w *Writer) WriteDouble(f float64) error {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if w.isResp3() {
+		w.conn.WriteRaw([]byte("," + s + "\r\n"))
+		return nil
+	}
+	w.conn.WriteBulkString(s)
+	return nil
+}
+
+// WriteBool writes b as a RESP3 boolean under RESP3, or as the classic
+// :1 / :0 integer reply under RESP2.
+func (w *Writer) WriteBool(b bool) error {
+	if w.isResp3() {
+		if b {
+			w.conn.WriteRaw([]byte("#t\r\n"))
+		} else {
+			w.conn.WriteRaw([]byte("#f\r\n"))
+		}
+		return nil
+	}
+	if b {
+		w.conn.WriteInt(1)
+	} else {
+		w.conn.WriteInt(0)
+	}
+	return nil
+}
+
+// WriteBigNumber writes s, a decimal digit string, as a RESP3 big number
+// under RESP3, or as a plain bulk string under RESP2.
+func (w *Writer) WriteBigNumber(s string) error {
+	if w.isResp3() {
+		w.conn.WriteRaw([]byte("(" + s + "\r\n"))
+		return nil
+	}
+	w.conn.WriteBulkString(s)
+	return nil
+}
+
+// WriteVerbatim writes s as a RESP3 verbatim string tagged with format
+// (a 3-byte type hint such as "txt" or "mkd") under RESP3, or as a plain
+// bulk string under RESP2.
+func (w *Writer) WriteVerbatim(format, s string) error {
+	if w.isResp3() {
+		payload := format + ":" + s
+		w.conn.WriteRaw([]byte("=" + strconv.Itoa(len(payload)) + "\r\n" + payload + "\r\n"))
+		return nil
+	}
+	w.conn.WriteBulkString(s)
+	return nil
+}
+
+// WriteNull writes a RESP3 null under RESP3, or the classic RESP2 $-1
+// nil bulk string under RESP2.
+func (w *Writer) WriteNull() error {
+	if w.isResp3() {
+		w.conn.WriteRaw([]byte("_\r\n"))
+		return nil
+	}
+	w.conn.WriteNull()
+	return nil
+}
+
+// writeResp2Scalar writes v the way a RESP2-only reply would encode it,
+// used both directly and as WriteMap/WriteSet's RESP2 fallback.
+func (w *Writer) writeResp2Scalar(v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		w.conn.WriteNull()
+	case string:
+		w.conn.WriteBulkString(t)
+	case []byte:
+		w.conn.WriteBulk(t)
+	case int:
+		w.conn.WriteInt(t)
+	case int64:
+		w.conn.WriteInt64(t)
+	case float64:
+		w.conn.WriteBulkString(strconv.FormatFloat(t, 'g', -1, 64))
+	case bool:
+		if t {
+			w.conn.WriteInt(1)
+		} else {
+			w.conn.WriteInt(0)
+		}
+	case []interface{}:
+		w.conn.WriteArray(len(t))
+		for _, e := range t {
+			if err := w.writeResp2Scalar(e); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported reply value type %T", v)
+	}
+	return nil
+}
+
+// encodeRESP3 renders v as a single RESP3-encoded value, used by
+// WriteMap/WriteSet to build the raw bytes for their elements.
+func encodeRESP3(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return []byte("_\r\n"), nil
+	case string:
+		return []byte("$" + strconv.Itoa(len(t)) + "\r\n" + t + "\r\n"), nil
+	case []byte:
+		return []byte("$" + strconv.Itoa(len(t)) + "\r\n" + string(t) + "\r\n"), nil
+	case int:
+		return []byte(":" + strconv.Itoa(t) + "\r\n"), nil
+	case int64:
+		return []byte(":" + strconv.FormatInt(t, 10) + "\r\n"), nil
+	case float64:
+		return []byte("," + strconv.FormatFloat(t, 'g', -1, 64) + "\r\n"), nil
+	case bool:
+		if t {
+			return []byte("#t\r\n"), nil
+		}
+		return []byte("#f\r\n"), nil
+	case []interface{}:
+		buf := []byte("*" + strconv.Itoa(len(t)) + "\r\n")
+		for _, e := range t {
+			b, err := encodeRESP3(e)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, b...)
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("unsupported reply value type %T", v)
+	}
+}
+
+// WriteHGetAllReply writes an HGETALL result (alternating field/value
+// pairs) as a RESP3 map or the classic RESP2 flat array, depending on
+// conn's negotiated protocol version.
+func WriteHGetAllReply(conn redcon.Conn, fieldVals [][2][]byte) error {
+	pairs := make([][2]interface{}, len(fieldVals))
+	for i, fv := range fieldVals {
+		pairs[i] = [2]interface{}{fv[0], fv[1]}
+	}
+	return NewWriter(conn).WriteMap(pairs)
+}
+
+// WriteConfigGetReply writes a CONFIG GET result (alternating
+// param/value pairs) the same way WriteHGetAllReply does.
+func WriteConfigGetReply(conn redcon.Conn, params [][2]string) error {
+	pairs := make([][2]interface{}, len(params))
+	for i, p := range params {
+		pairs[i] = [2]interface{}{p[0], p[1]}
+	}
+	return NewWriter(conn).WriteMap(pairs)
+}
+
+// WriteClientInfoReply writes a CLIENT INFO-style reply (its usual
+// space-separated key=value fields) as a RESP3 map under RESP3, or as a
+// single bulk string in the classic "k1=v1 k2=v2 ..." format under
+// RESP2.
+func WriteClientInfoReply(conn redcon.Conn, fields [][2]string) error {
+	w := NewWriter(conn)
+	if w.isResp3() {
+		pairs := make([][2]interface{}, len(fields))
+		for i, f := range fields {
+			pairs[i] = [2]interface{}{f[0], f[1]}
+		}
+		return w.WriteMap(pairs)
+	}
+	var sb strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(f[0])
+		sb.WriteByte('=')
+		sb.WriteString(f[1])
+	}
+	w.conn.WriteBulkString(sb.String())
+	return nil
+}
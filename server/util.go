@@ -7,6 +7,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/absolute8511/redcon"
 	"github.com/youzan/ZanRedisDB/common"
@@ -40,110 +41,491 @@ func GetIPv4ForInterfaceName(ifname string) string {
 	return ""
 }
 
-// pipelineCommand creates a single command from a pipeline.
-// should handle some pipeline command which across multi partitions
-// since plget response is a bit complicated (order require), we do not handle pipeline for get
-func pipelineCommand(conn redcon.Conn, cmd redcon.Command) (int, redcon.Command, error) {
+// plCoalesceSpec describes how a run of single-key cmdName invocations
+// folds into the internal multi-key command named plCmd: argc is the
+// required redcon.Command.Args length (including the command name) for a
+// coalescable invocation, and keyArgs lists which argument offsets get
+// appended, in order, for each sub-command folded into the merged args.
+type plCoalesceSpec struct {
+	plCmd   string
+	argc    int
+	keyArgs []int
+}
+
+var plCoalesceSpecs = map[string]plCoalesceSpec{
+	"get":    {plCmd: "plget", argc: 2, keyArgs: []int{1}},
+	"del":    {plCmd: "pldel", argc: 2, keyArgs: []int{1}},
+	"exists": {plCmd: "plexists", argc: 2, keyArgs: []int{1}},
+	"set":    {plCmd: "plset", argc: 3, keyArgs: []int{1, 2}},
+	"hget":   {plCmd: "plhget", argc: 3, keyArgs: []int{1, 2}},
+	"hset":   {plCmd: "plhset", argc: 4, keyArgs: []int{1, 2, 3}},
+	"hdel":   {plCmd: "plhdel", argc: 3, keyArgs: []int{1, 2}},
+}
+
+// adminCommandHandlers are commands pipelineCommand dispatches to directly
+// rather than treating as coalescable or pipelined-through: each writes
+// its own reply straight to conn and has no per-key batch to fold or fan
+// out, so they bypass plCoalesceSpecs entirely. pipelineCommand is the
+// closest thing this package has to a connection-level command
+// dispatcher, so it's where commands like HELLO get registered to be
+// reachable at all; it looks up only the command it is called with, not
+// the ones it drains from behind a coalescable command.
+var adminCommandHandlers = map[string]func(conn redcon.Conn, cmd redcon.Command) error{
+	"hello":          HandleHello,
+	"pipeline.stats": HandlePipelineStats,
+}
+
+// writeJournal, when installed via SetWriteJournal, makes runPipelineFanout
+// append each key (synced per the journal's Fsync policy) to it before
+// running exec for that key, acking
+// it once exec succeeds - closing the crash window WriteJournal's own doc
+// comment describes between "client acked" and "committed" for the one
+// per-key write-fanout path this package has. A nil journal (the default)
+// runs exec directly, unjournaled.
+var writeJournal *WriteJournal
+
+// SetWriteJournal installs journal as runPipelineFanout's durability layer;
+// pass nil to go back to running exec directly with no journaling.
+func SetWriteJournal(journal *WriteJournal) {
+	writeJournal = journal
+}
+
+// partitionRing, when set via SetPartitionRing, makes pipelineCommand split
+// a coalesced run across partitions (via SplitPipelineByPartition) instead
+// of folding it into a single PL* command blind to partition boundaries. A
+// nil ring (the default) preserves the original blind-fold behavior.
+var partitionRing *hashRing
+
+// SetPartitionRing installs ring as pipelineCommand's partition-aware
+// coalescer; pass nil to revert to folding every run into one command
+// regardless of which partition each key belongs to.
+func SetPartitionRing(ring *hashRing) {
+	partitionRing = ring
+}
+
+// pipelineCommand looks at cmd together with whatever is already
+// pipelined behind it and folds every run of same-shaped single-key
+// commands (GET, DEL, EXISTS, SET, HGET, HSET, HDEL) into one internal
+// multi-key command (PLGET, PLDEL, ...) that can fan out across
+// partitions in parallel instead of paying one round trip per key. A run
+// is any maximal stretch of consecutive pipelined commands sharing the
+// same name and arg count; a command the coalescer doesn't recognize (or
+// a run of length 1) is passed through unchanged.
+//
+// redcon's Conn.ReadPipeline drains the whole peeked pipeline in one
+// shot with no partial variant, so a mixed pipeline can't be handled by
+// only consuming a matched prefix and leaving the rest queued for a
+// later call: instead we drain everything up front and return the full,
+// ordered sequence of commands the caller must now execute — merged
+// batches where a run was found, original commands everywhere else.
+// Executing that sequence in order and writing one reply (or, for a
+// merged batch, one reply per folded sub-command, via runPipelineFanout)
+// per entry reproduces exactly the client's original N replies in
+// submission order.
+//
+// pipelineCommand also doubles as the dispatch point for admin commands
+// that reply for themselves instead of folding into a batch (see
+// adminCommandHandlers): when cmd itself is HELLO or PIPELINE.STATS it is
+// handled here rather than falling through to the coalescer. An admin
+// command drained from behind a coalescable cmd is not dispatched; it is
+// returned in the output sequence like any other unrecognized command.
+// When a partition ring is
+// installed via SetPartitionRing, a coalescable run is split per-partition
+// with SplitPipelineByPartition instead of being folded blind to partition
+// boundaries.
+func pipelineCommand(conn redcon.Conn, cmd redcon.Command) (int, []redcon.Command, error) {
 	if conn == nil {
-		return 0, cmd, nil
+		return 0, []redcon.Command{cmd}, nil
+	}
+	cmdName := qcmdlower(cmd.Args[0])
+	if h, ok := adminCommandHandlers[cmdName]; ok {
+		return 0, nil, h(conn, cmd)
+	}
+	switch cmdName {
+	case "plget", "plset", "pldel", "plexists", "plhget", "plhset", "plhdel":
+		return 0, nil, ErrUnknownCommand
+	}
+	if _, ok := plCoalesceSpecs[cmdName]; !ok {
+		return 0, []redcon.Command{cmd}, nil
 	}
 	pcmds := conn.PeekPipeline()
 	if len(pcmds) == 0 {
-		return 0, cmd, nil
+		return 0, []redcon.Command{cmd}, nil
 	}
-	args := make([][]byte, 0, 64)
-	switch qcmdlower(cmd.Args[0]) {
-	default:
-		return 0, cmd, nil
-	case "plget", "plset":
-		return 0, redcon.Command{}, ErrUnknownCommand
-	case "set":
-		if len(cmd.Args) != 3 {
-			return 0, cmd, nil
-		}
-		// convert to a PLSET command which is similar to an MSET
-		for _, pcmd := range pcmds {
-			if qcmdlower(pcmd.Args[0]) != "set" || len(pcmd.Args) != 3 {
-				return 0, cmd, nil
+	// remove the peeked items off the pipeline
+	conn.ReadPipeline()
+
+	all := append([]redcon.Command{cmd}, pcmds...)
+	out := make([]redcon.Command, 0, len(all))
+	for i := 0; i < len(all); {
+		name := qcmdlower(all[i].Args[0])
+		spec, ok := plCoalesceSpecs[name]
+		if !ok || len(all[i].Args) != spec.argc {
+			out = append(out, all[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(all) && qcmdlower(all[j].Args[0]) == name && len(all[j].Args) == spec.argc {
+			j++
+		}
+		recordPipelineRun(j - i)
+		if j-i == 1 {
+			out = append(out, all[i])
+			i++
+			continue
+		}
+		if partitionRing != nil {
+			batches, err := SplitPipelineByPartition(partitionRing, spec, all[i:j])
+			if err != nil {
+				return 0, nil, err
+			}
+			for _, b := range batches {
+				out = append(out, b.Cmd)
 			}
+			i = j
+			continue
 		}
-		args = append(args, []byte("plset"))
-		for _, pcmd := range append([]redcon.Command{cmd}, pcmds...) {
-			args = append(args, pcmd.Args[1], pcmd.Args[2])
+		args := make([][]byte, 0, 1+len(spec.keyArgs)*(j-i))
+		args = append(args, []byte(spec.plCmd))
+		for _, sub := range all[i:j] {
+			for _, idx := range spec.keyArgs {
+				args = append(args, sub.Args[idx])
+			}
 		}
+		out = append(out, buildCommand(args))
+		i = j
 	}
+	return len(pcmds), out, nil
+}
 
-	// remove the peeked items off the pipeline
-	conn.ReadPipeline()
+// plResult is one coalesced sub-command's reply, stamped with its
+// 0-based index within the merged batch so replies that race home from
+// different partitions can still be reassembled in submission order.
+type plResult struct {
+	Index int
+	Reply interface{}
+	Err   error
+}
 
-	ncmd := buildCommand(args)
-	return len(pcmds) + 1, ncmd, nil
+// runPipelineFanout runs exec concurrently for every key (one goroutine
+// per key, fanning out across whichever partition owns it) and returns
+// their results indexed by submission order, regardless of which
+// goroutine finishes first. Callers range over the returned slice in
+// order to emit replies exactly as the client originally pipelined them.
+// When a WriteJournal is installed via SetWriteJournal, each key's exec
+// call is wrapped in Append/Ack so the batch is durable before it runs.
+func runPipelineFanout(keys [][]byte, exec func(index int, key []byte) (interface{}, error)) []plResult {
+	results := make([]plResult, len(keys))
+	var wg sync.WaitGroup
+	wg.Add(len(keys))
+	for i, k := range keys {
+		go func(i int, k []byte) {
+			defer wg.Done()
+			if writeJournal == nil {
+				reply, err := exec(i, k)
+				results[i] = plResult{Index: i, Reply: reply, Err: err}
+				return
+			}
+			lsn, err := writeJournal.Append(k)
+			if err != nil {
+				results[i] = plResult{Index: i, Err: err}
+				return
+			}
+			reply, err := exec(i, k)
+			if err == nil {
+				writeJournal.Ack(lsn)
+			}
+			results[i] = plResult{Index: i, Reply: reply, Err: err}
+		}(i, k)
+	}
+	wg.Wait()
+	return results
 }
 
 func buildCommand(args [][]byte) redcon.Command {
 	return common.BuildCommand(args)
 }
 
-func parseCommand(raw []byte) (redcon.Command, error) {
+// Kind identifies which wire framing a parsed command used, mirroring
+// redcon.ReadNextCommand's Redis/Tile38/Telnet distinction so a caller
+// looping over a multi-command packet (or choosing a reply encoder) knows
+// which dialect it is speaking.
+type Kind int
+
+const (
+	Redis Kind = iota
+	Tile38
+	Telnet
+)
+
+// DrainRawPipeline loops parseCommand over raw until it is fully consumed,
+// so a caller handling a raw (non-redcon) connection — a plain telnet
+// client, or an inline/Tile38-framed one — can get the same
+// "drain-everything-up-front, execute-in-order" sequence pipelineCommand
+// already gives a redcon.Conn. cmds[i] was framed as kinds[i]; a parse
+// error on a later command in the packet still returns everything parsed
+// before it, alongside the error, so the caller can execute what did parse
+// rather than discarding the whole packet.
+func DrainRawPipeline(raw []byte) (cmds []redcon.Command, kinds []Kind, err error) {
+	for len(raw) > 0 {
+		var cmd redcon.Command
+		var kind Kind
+		cmd, kind, raw, err = parseCommand(raw)
+		if err != nil {
+			return cmds, kinds, err
+		}
+		cmds = append(cmds, cmd)
+		kinds = append(kinds, kind)
+	}
+	return cmds, kinds, nil
+}
+
+// parseCommand parses one command off the front of raw and returns it
+// along with the Kind of framing used and whatever bytes remain after it,
+// so the caller can loop parseCommand(leftover) to drain a packet holding
+// several pipelined commands. It dispatches on the leading byte: '*' is a
+// standard RESP bulk array, '$' is a Tile38-native length-prefixed single
+// frame, and anything else is treated as a CRLF/LF-terminated inline
+// (telnet) command.
+func parseCommand(raw []byte) (redcon.Command, Kind, []byte, error) {
+	if len(raw) == 0 {
+		return redcon.Command{}, Redis, raw, errors.New("invalid command")
+	}
+	switch raw[0] {
+	case '*':
+		cmd, leftover, err := parseRedisCommand(raw)
+		return cmd, Redis, leftover, err
+	case '$':
+		cmd, leftover, err := parseTile38Command(raw)
+		return cmd, Tile38, leftover, err
+	default:
+		cmd, leftover, err := parseTelnetCommand(raw)
+		return cmd, Telnet, leftover, err
+	}
+}
+
+func parseRedisCommand(raw []byte) (redcon.Command, []byte, error) {
 	var cmd redcon.Command
-	cmd.Raw = raw
 	pos := 0
 	rd := bufio.NewReader(bytes.NewBuffer(raw))
 	c, err := rd.ReadByte()
 	if err != nil {
-		return cmd, err
+		return cmd, raw, err
 	}
 	pos++
 	if c != '*' {
-		return cmd, errors.New("invalid command")
+		return cmd, raw, errors.New("invalid command")
 	}
 	line, err := rd.ReadString('\n')
 	if err != nil {
-		return cmd, err
+		return cmd, raw, err
 	}
 	pos += len(line)
 	if len(line) < 2 || line[len(line)-2] != '\r' {
-		return cmd, errors.New("invalid command")
+		return cmd, raw, errors.New("invalid command")
 	}
 	n, err := strconv.ParseUint(line[:len(line)-2], 10, 64)
 	if err != nil {
-		return cmd, err
+		return cmd, raw, err
 	}
 	if n == 0 {
-		return cmd, errors.New("invalid command")
+		return cmd, raw, errors.New("invalid command")
 	}
 	for i := uint64(0); i < n; i++ {
 		c, err := rd.ReadByte()
 		if err != nil {
-			return cmd, err
+			return cmd, raw, err
 		}
 		pos++
 		if c != '$' {
-			return cmd, errors.New("invalid command")
+			return cmd, raw, errors.New("invalid command")
 		}
 		line, err := rd.ReadString('\n')
 		if err != nil {
-			return cmd, err
+			return cmd, raw, err
 		}
 		pos += len(line)
 		if len(line) < 2 || line[len(line)-2] != '\r' {
-			return cmd, errors.New("invalid command")
+			return cmd, raw, errors.New("invalid command")
 		}
 		n, err := strconv.ParseUint(line[:len(line)-2], 10, 64)
 		if err != nil {
-			return cmd, err
+			return cmd, raw, err
 		}
 		if _, err := rd.Discard(int(n) + 2); err != nil {
-			return cmd, err
+			return cmd, raw, err
 		}
 		s := pos
 		pos += int(n) + 2
-		if raw[pos-2] != '\r' || raw[pos-1] != '\n' {
-			return cmd, errors.New("invalid command")
+		if pos > len(raw) || raw[pos-2] != '\r' || raw[pos-1] != '\n' {
+			return cmd, raw, errors.New("invalid command")
 		}
 		cmd.Args = append(cmd.Args, raw[s:pos-2])
 	}
-	return cmd, nil
+	cmd.Raw = raw[:pos]
+	return cmd, raw[pos:], nil
+}
+
+// parseTile38Command reads a single Tile38-native frame: a length-prefixed
+// bulk string whose payload is itself an inline command, e.g.
+// "$4\r\nPING\r\n".
+func parseTile38Command(raw []byte) (redcon.Command, []byte, error) {
+	var cmd redcon.Command
+	pos := 1
+	idx := bytes.IndexByte(raw[pos:], '\n')
+	if idx < 0 {
+		return cmd, raw, errors.New("invalid command")
+	}
+	lenLine := raw[pos : pos+idx+1]
+	pos += idx + 1
+	if len(lenLine) < 2 || lenLine[len(lenLine)-2] != '\r' {
+		return cmd, raw, errors.New("invalid command")
+	}
+	n, err := strconv.ParseUint(string(lenLine[:len(lenLine)-2]), 10, 64)
+	if err != nil {
+		return cmd, raw, err
+	}
+	if pos+int(n)+2 > len(raw) {
+		return cmd, raw, errors.New("invalid command")
+	}
+	payload := raw[pos : pos+int(n)]
+	pos += int(n)
+	if raw[pos] != '\r' || raw[pos+1] != '\n' {
+		return cmd, raw, errors.New("invalid command")
+	}
+	pos += 2
+	args, err := splitInlineArgs(payload)
+	if err != nil {
+		return cmd, raw[pos:], err
+	}
+	if len(args) == 0 {
+		return cmd, raw[pos:], errors.New("invalid command")
+	}
+	cmd.Raw = raw[:pos]
+	cmd.Args = args
+	return cmd, raw[pos:], nil
+}
+
+// parseTelnetCommand reads a single CRLF- or LF-terminated inline command,
+// the format a plain `nc`/telnet client (or redis-cli in inline mode)
+// sends, splitting on whitespace with "..."/'...' quoted-arg handling.
+func parseTelnetCommand(raw []byte) (redcon.Command, []byte, error) {
+	var cmd redcon.Command
+	idx := bytes.IndexByte(raw, '\n')
+	if idx < 0 {
+		return cmd, raw, errors.New("invalid command")
+	}
+	line := raw[:idx+1]
+	leftover := raw[idx+1:]
+	trimmed := line[:len(line)-1]
+	if len(trimmed) > 0 && trimmed[len(trimmed)-1] == '\r' {
+		trimmed = trimmed[:len(trimmed)-1]
+	}
+	args, err := splitInlineArgs(trimmed)
+	if err != nil {
+		return cmd, leftover, err
+	}
+	if len(args) == 0 {
+		return cmd, leftover, errors.New("invalid command")
+	}
+	cmd.Raw = line
+	cmd.Args = args
+	return cmd, leftover, nil
+}
+
+// splitInlineArgs tokenizes an inline command line the same way redis'
+// sdssplitargs does: whitespace-separated tokens, with "..." and '...'
+// quoting, and \n \t \r \\ \" \' escapes recognized inside double quotes
+// (inside single quotes only \' is an escape, yielding a literal quote).
+func splitInlineArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	p := line
+	for {
+		for len(p) > 0 && (p[0] == ' ' || p[0] == '\t') {
+			p = p[1:]
+		}
+		if len(p) == 0 {
+			break
+		}
+		var cur []byte
+		inDouble := false
+		inSingle := false
+		for {
+			if inDouble {
+				if len(p) == 0 {
+					return nil, errors.New("unbalanced quotes in inline command")
+				}
+				if p[0] == '\\' && len(p) >= 2 {
+					switch p[1] {
+					case 'n':
+						cur = append(cur, '\n')
+					case 'r':
+						cur = append(cur, '\r')
+					case 't':
+						cur = append(cur, '\t')
+					default:
+						// \\, \", \' and anything else just yield the
+						// escaped byte literally.
+						cur = append(cur, p[1])
+					}
+					p = p[2:]
+					continue
+				}
+				if p[0] == '"' {
+					if len(p) > 1 && p[1] != ' ' && p[1] != '\t' {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					p = p[1:]
+					inDouble = false
+					break
+				}
+				cur = append(cur, p[0])
+				p = p[1:]
+				continue
+			}
+			if inSingle {
+				if len(p) == 0 {
+					return nil, errors.New("unbalanced quotes in inline command")
+				}
+				if p[0] == '\\' && len(p) >= 2 && p[1] == '\'' {
+					cur = append(cur, '\'')
+					p = p[2:]
+					continue
+				}
+				if p[0] == '\'' {
+					if len(p) > 1 && p[1] != ' ' && p[1] != '\t' {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					p = p[1:]
+					inSingle = false
+					break
+				}
+				cur = append(cur, p[0])
+				p = p[1:]
+				continue
+			}
+			if len(p) == 0 || p[0] == ' ' || p[0] == '\t' {
+				break
+			}
+			switch p[0] {
+			case '"':
+				inDouble = true
+				p = p[1:]
+			case '\'':
+				inSingle = true
+				p = p[1:]
+			default:
+				cur = append(cur, p[0])
+				p = p[1:]
+			}
+		}
+		if inDouble || inSingle {
+			return nil, errors.New("unbalanced quotes in inline command")
+		}
+		args = append(args, cur)
+	}
+	return args, nil
 }
 
 // qcmdlower for common optimized command lowercase conversions.
@@ -160,6 +542,11 @@ func qcmdlower(n []byte) string {
 			(n[2] == 't' || n[2] == 'T') {
 			return "get"
 		}
+		if (n[0] == 'd' || n[0] == 'D') &&
+			(n[1] == 'e' || n[1] == 'E') &&
+			(n[2] == 'l' || n[2] == 'L') {
+			return "del"
+		}
 	case 4:
 		if (n[0] == 'm' || n[0] == 'M') &&
 			(n[1] == 's' || n[1] == 'S') &&
@@ -179,6 +566,24 @@ func qcmdlower(n []byte) string {
 			(n[3] == 'l' || n[3] == 'L') {
 			return "eval"
 		}
+		if (n[0] == 'h' || n[0] == 'H') &&
+			(n[1] == 'g' || n[1] == 'G') &&
+			(n[2] == 'e' || n[2] == 'E') &&
+			(n[3] == 't' || n[3] == 'T') {
+			return "hget"
+		}
+		if (n[0] == 'h' || n[0] == 'H') &&
+			(n[1] == 's' || n[1] == 'S') &&
+			(n[2] == 'e' || n[2] == 'E') &&
+			(n[3] == 't' || n[3] == 'T') {
+			return "hset"
+		}
+		if (n[0] == 'h' || n[0] == 'H') &&
+			(n[1] == 'd' || n[1] == 'D') &&
+			(n[2] == 'e' || n[2] == 'E') &&
+			(n[3] == 'l' || n[3] == 'L') {
+			return "hdel"
+		}
 	case 5:
 		if (n[0] == 'p' || n[0] == 'P') &&
 			(n[1] == 'l' || n[1] == 'L') &&
@@ -194,6 +599,20 @@ func qcmdlower(n []byte) string {
 			(n[4] == 't' || n[4] == 'T') {
 			return "plget"
 		}
+		if (n[0] == 'p' || n[0] == 'P') &&
+			(n[1] == 'l' || n[1] == 'L') &&
+			(n[2] == 'd' || n[2] == 'D') &&
+			(n[3] == 'e' || n[3] == 'E') &&
+			(n[4] == 'l' || n[4] == 'L') {
+			return "pldel"
+		}
+		if (n[0] == 'h' || n[0] == 'H') &&
+			(n[1] == 'e' || n[1] == 'E') &&
+			(n[2] == 'l' || n[2] == 'L') &&
+			(n[3] == 'l' || n[3] == 'L') &&
+			(n[4] == 'o' || n[4] == 'O') {
+			return "hello"
+		}
 	case 6:
 		if (n[0] == 'e' || n[0] == 'E') &&
 			(n[1] == 'v' || n[1] == 'V') &&
@@ -203,6 +622,49 @@ func qcmdlower(n []byte) string {
 			(n[5] == 'o' || n[5] == 'O') {
 			return "evalro"
 		}
+		if (n[0] == 'e' || n[0] == 'E') &&
+			(n[1] == 'x' || n[1] == 'X') &&
+			(n[2] == 'i' || n[2] == 'I') &&
+			(n[3] == 's' || n[3] == 'S') &&
+			(n[4] == 't' || n[4] == 'T') &&
+			(n[5] == 's' || n[5] == 'S') {
+			return "exists"
+		}
+		if (n[0] == 'p' || n[0] == 'P') &&
+			(n[1] == 'l' || n[1] == 'L') &&
+			(n[2] == 'h' || n[2] == 'H') &&
+			(n[3] == 'g' || n[3] == 'G') &&
+			(n[4] == 'e' || n[4] == 'E') &&
+			(n[5] == 't' || n[5] == 'T') {
+			return "plhget"
+		}
+		if (n[0] == 'p' || n[0] == 'P') &&
+			(n[1] == 'l' || n[1] == 'L') &&
+			(n[2] == 'h' || n[2] == 'H') &&
+			(n[3] == 's' || n[3] == 'S') &&
+			(n[4] == 'e' || n[4] == 'E') &&
+			(n[5] == 't' || n[5] == 'T') {
+			return "plhset"
+		}
+		if (n[0] == 'p' || n[0] == 'P') &&
+			(n[1] == 'l' || n[1] == 'L') &&
+			(n[2] == 'h' || n[2] == 'H') &&
+			(n[3] == 'd' || n[3] == 'D') &&
+			(n[4] == 'e' || n[4] == 'E') &&
+			(n[5] == 'l' || n[5] == 'L') {
+			return "plhdel"
+		}
+	case 8:
+		if (n[0] == 'p' || n[0] == 'P') &&
+			(n[1] == 'l' || n[1] == 'L') &&
+			(n[2] == 'e' || n[2] == 'E') &&
+			(n[3] == 'x' || n[3] == 'X') &&
+			(n[4] == 'i' || n[4] == 'I') &&
+			(n[5] == 's' || n[5] == 'S') &&
+			(n[6] == 't' || n[6] == 'T') &&
+			(n[7] == 's' || n[7] == 'S') {
+			return "plexists"
+		}
 	}
 	return strings.ToLower(string(n))
 }
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/absolute8511/redcon"
+)
+
+// fakeConn is a minimal redcon.Conn that needs no real network connection:
+// PeekPipeline/ReadPipeline serve a fixed, preloaded batch (exactly what a
+// real redcon.Conn would have already buffered from the wire), and every
+// Write* call just records what it was given so a test can assert on the
+// replies pipelineCommand/runPipelineFanout would have sent.
+type fakeConn struct {
+	pipeline []redcon.Command
+	ctx      interface{}
+	writes   []interface{}
+}
+
+func (c *fakeConn) RemoteAddr() string             { return "fake" }
+func (c *fakeConn) Close() error                   { return nil }
+func (c *fakeConn) WriteError(msg string)          { c.writes = append(c.writes, msg) }
+func (c *fakeConn) WriteString(str string)         { c.writes = append(c.writes, str) }
+func (c *fakeConn) WriteBulk(bulk []byte)          { c.writes = append(c.writes, append([]byte(nil), bulk...)) }
+func (c *fakeConn) WriteBulkString(bulk string)    { c.writes = append(c.writes, bulk) }
+func (c *fakeConn) WriteInt(num int)               { c.writes = append(c.writes, num) }
+func (c *fakeConn) WriteInt64(num int64)           { c.writes = append(c.writes, num) }
+func (c *fakeConn) WriteUint64(num uint64)         { c.writes = append(c.writes, num) }
+func (c *fakeConn) WriteArray(count int)           { c.writes = append(c.writes, count) }
+func (c *fakeConn) WriteNull()                     { c.writes = append(c.writes, nil) }
+func (c *fakeConn) WriteRaw(data []byte)           { c.writes = append(c.writes, append([]byte(nil), data...)) }
+func (c *fakeConn) WriteAny(v interface{})         { c.writes = append(c.writes, v) }
+func (c *fakeConn) SetReadBuffer(bytes int)        {}
+func (c *fakeConn) Context() interface{}           { return c.ctx }
+func (c *fakeConn) SetContext(v interface{})       { c.ctx = v }
+func (c *fakeConn) Detach() redcon.DetachedConn    { return nil }
+func (c *fakeConn) NetConn() net.Conn              { return nil }
+func (c *fakeConn) PeekPipeline() []redcon.Command { return c.pipeline }
+func (c *fakeConn) ReadPipeline() []redcon.Command {
+	p := c.pipeline
+	c.pipeline = nil
+	return p
+}
+
+func mkCmd(args ...string) redcon.Command {
+	bs := make([][]byte, len(args))
+	for i, a := range args {
+		bs[i] = []byte(a)
+	}
+	return buildCommand(bs)
+}
+
+func TestPipelineCommandCoalescesRun(t *testing.T) {
+	conn := &fakeConn{pipeline: []redcon.Command{
+		mkCmd("get", "k2"),
+		mkCmd("get", "k3"),
+	}}
+	n, out, err := pipelineCommand(conn, mkCmd("get", "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 pipelined commands consumed, got %v", n)
+	}
+	if len(out) != 1 {
+		t.Fatalf("expected the GET run to fold into one command, got %v", out)
+	}
+	got := qcmdlower(out[0].Args[0])
+	if got != "plget" {
+		t.Fatalf("expected the folded command to be plget, got %v", got)
+	}
+	wantKeys := [][]byte{[]byte("k1"), []byte("k2"), []byte("k3")}
+	if !reflect.DeepEqual(out[0].Args[1:], wantKeys) {
+		t.Fatalf("unexpected folded args: %v", out[0].Args[1:])
+	}
+}
+
+func TestPipelineCommandPassesThroughSingletonRun(t *testing.T) {
+	conn := &fakeConn{pipeline: []redcon.Command{
+		mkCmd("set", "k2", "v2"),
+	}}
+	_, out, err := pipelineCommand(conn, mkCmd("get", "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected both commands passed through unfolded, got %v", out)
+	}
+	if qcmdlower(out[0].Args[0]) != "get" || qcmdlower(out[1].Args[0]) != "set" {
+		t.Fatalf("unexpected command order/names: %v", out)
+	}
+}
+
+func TestPipelineCommandMixedRunsFoldIndependently(t *testing.T) {
+	conn := &fakeConn{pipeline: []redcon.Command{
+		mkCmd("get", "k2"),
+		mkCmd("set", "k3", "v3"),
+		mkCmd("set", "k4", "v4"),
+		mkCmd("del", "k5"),
+	}}
+	_, out, err := pipelineCommand(conn, mkCmd("get", "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GET k1,k2 folds, SET k3,k4 folds, DEL k5 is a run of one and passes
+	// through unfolded: three entries total.
+	if len(out) != 3 {
+		t.Fatalf("expected 3 output entries, got %v: %v", len(out), out)
+	}
+	names := []string{qcmdlower(out[0].Args[0]), qcmdlower(out[1].Args[0]), qcmdlower(out[2].Args[0])}
+	want := []string{"plget", "plset", "del"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected fold sequence: got %v, want %v", names, want)
+	}
+}
+
+func TestPipelineCommandDispatchesAdminHandler(t *testing.T) {
+	conn := &fakeConn{}
+	n, out, err := pipelineCommand(conn, mkCmd("hello", "3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || out != nil {
+		t.Fatalf("expected HELLO to be fully handled with no commands returned, got n=%v out=%v", n, out)
+	}
+	if connProtoVer(conn) != respProto3 {
+		t.Fatalf("expected HandleHello to negotiate RESP3, got proto %v", connProtoVer(conn))
+	}
+}
+
+func TestRunPipelineFanoutPreservesSubmissionOrder(t *testing.T) {
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	results := runPipelineFanout(keys, func(index int, key []byte) (interface{}, error) {
+		// reverse-order completion to prove ordering isn't just luck of
+		// goroutine scheduling
+		time.Sleep(time.Duration(len(keys)-index) * 10 * time.Millisecond)
+		return string(key) + "-reply", nil
+	})
+	for i, want := range []string{"a-reply", "b-reply", "c-reply"} {
+		if results[i].Index != i || results[i].Reply != want || results[i].Err != nil {
+			t.Fatalf("unexpected result at %v: %+v", i, results[i])
+		}
+	}
+}
+
+func TestRunPipelineFanoutJournalsWhenInstalled(t *testing.T) {
+	dir := t.TempDir()
+	var applied []int64
+	journal, err := NewWriteJournal(JournalConfig{
+		Dir:            dir,
+		MaxSegmentSize: 1 << 20,
+		Fsync:          "never",
+	}, func(lsn int64, payload []byte) error {
+		applied = append(applied, lsn)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetWriteJournal(journal)
+	defer SetWriteJournal(nil)
+
+	keys := [][]byte{[]byte("a"), []byte("b")}
+	results := runPipelineFanout(keys, func(index int, key []byte) (interface{}, error) {
+		return "ok", nil
+	})
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("unexpected error at %v: %v", i, r.Err)
+		}
+	}
+	if journal.Depth() != 2 {
+		t.Fatalf("expected both keys appended to the journal, depth=%v", journal.Depth())
+	}
+}